@@ -0,0 +1,177 @@
+package goev
+
+import (
+	"sync"
+	"syscall"
+)
+
+// acceptLimiter is the admission control of Acceptor, configured by options.AcceptFilter,
+// AcceptMaxConns, AcceptMaxConnsPerIP and AcceptRateLimit.
+//
+// The counters are incremented within the acceptor evpoll, and decremented by
+// IOHandle.Destroy of the handler (maybe in another evpoll)
+type acceptLimiter struct {
+	filter func(fd int, sa syscall.Sockaddr) bool
+
+	maxConns    int
+	maxPerIP    int
+	ipv4Prefix  int
+	ipv6Prefix  int
+	ratePerSec  int
+	rateBurst   int
+	tokens      float64
+	tokensMtime int64 // unix millisecond
+
+	mtx   sync.Mutex
+	conns int
+	perIP map[acceptIPKey]int
+}
+
+type acceptIPKey struct {
+	family int
+	ip     [16]byte // masked by the prefix length
+}
+
+func newAcceptLimiter(o *options) *acceptLimiter {
+	if o.acceptFilter == nil && o.acceptMaxConns < 1 && o.acceptMaxConnsPerIP < 1 &&
+		o.acceptRatePerSec < 1 {
+		return nil
+	}
+	l := &acceptLimiter{
+		filter:     o.acceptFilter,
+		maxConns:   o.acceptMaxConns,
+		maxPerIP:   o.acceptMaxConnsPerIP,
+		ipv4Prefix: o.acceptIPv4Prefix,
+		ipv6Prefix: o.acceptIPv6Prefix,
+		ratePerSec: o.acceptRatePerSec,
+		rateBurst:  o.acceptRateBurst,
+		tokens:     float64(o.acceptRateBurst),
+	}
+	if l.maxPerIP > 0 {
+		l.perIP = make(map[acceptIPKey]int, 1024)
+	}
+	return l
+}
+
+// admit returns false if the new connection should be rejected (closed), release must be
+// called when the connection is closed if it's not nil
+func (l *acceptLimiter) admit(fd int, sa syscall.Sockaddr, now int64) (release func(), ok bool) {
+	if l.filter != nil && l.filter(fd, sa) == false {
+		return nil, false
+	}
+	if l.maxConns < 1 && l.maxPerIP < 1 {
+		if l.ratePerSec > 0 && l.takeToken(now) == false {
+			return nil, false
+		}
+		return nil, true
+	}
+
+	key, hasIP := l.ipKey(sa)
+	hasIP = hasIP && l.maxPerIP > 0
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.maxConns > 0 && l.conns >= l.maxConns {
+		return nil, false
+	}
+	if hasIP && l.perIP[key] >= l.maxPerIP {
+		return nil, false
+	}
+	// After the limits, the rejected connections don't take the tokens
+	if l.ratePerSec > 0 && l.takeToken(now) == false {
+		return nil, false
+	}
+	l.conns++
+	if hasIP {
+		l.perIP[key]++
+	}
+	return func() {
+		l.mtx.Lock()
+		l.conns--
+		if hasIP {
+			if n := l.perIP[key] - 1; n > 0 {
+				l.perIP[key] = n
+			} else {
+				delete(l.perIP, key)
+			}
+		}
+		l.mtx.Unlock()
+	}, true
+}
+
+// takeToken token bucket, within the acceptor evpoll
+func (l *acceptLimiter) takeToken(now int64) bool {
+	if elapsed := now - l.tokensMtime; elapsed > 0 {
+		l.tokens += float64(elapsed) * float64(l.ratePerSec) / 1000
+		if l.tokens > float64(l.rateBurst) {
+			l.tokens = float64(l.rateBurst)
+		}
+		l.tokensMtime = now
+	}
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+func (l *acceptLimiter) ipKey(sa syscall.Sockaddr) (key acceptIPKey, ok bool) {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		key.family = syscall.AF_INET
+		copy(key.ip[:], sa.Addr[:])
+		maskIP(key.ip[:4], l.ipv4Prefix)
+		return key, true
+	case *syscall.SockaddrInet6:
+		key.ip = sa.Addr
+		if isIPv4Mapped(key.ip[:]) { // Dual-stack listener
+			key.family = syscall.AF_INET
+			copy(key.ip[:], sa.Addr[12:])
+			for i := 4; i < 16; i++ {
+				key.ip[i] = 0
+			}
+			maskIP(key.ip[:4], l.ipv4Prefix)
+			return key, true
+		}
+		key.family = syscall.AF_INET6
+		maskIP(key.ip[:], l.ipv6Prefix)
+		return key, true
+	}
+	return key, false // e.g. unix socket
+}
+
+func isIPv4Mapped(ip []byte) bool {
+	for i := 0; i < 10; i++ {
+		if ip[i] != 0 {
+			return false
+		}
+	}
+	return ip[10] == 0xff && ip[11] == 0xff
+}
+
+// maskIP keeps the first prefixLen bits of ip
+func maskIP(ip []byte, prefixLen int) {
+	for i := range ip {
+		bits := prefixLen - i*8
+		if bits >= 8 {
+			continue
+		}
+		if bits <= 0 {
+			ip[i] = 0
+		} else {
+			ip[i] &= ^byte(0xff >> bits)
+		}
+	}
+}
+
+// ConnNum returns the number of the accepted connections that have not been destroyed,
+// only counted if options.AcceptMaxConns or AcceptMaxConnsPerIP is set
+//
+// It is safe for concurrent use by multiple goroutines
+func (a *Acceptor) ConnNum() int {
+	if a.limiter == nil {
+		return 0
+	}
+	a.limiter.mtx.Lock()
+	defer a.limiter.mtx.Unlock()
+	return a.limiter.conns
+}
@@ -1,11 +1,12 @@
 package goev
 
 import (
+	"crypto/tls"
 	"errors"
 	"net"
 	"os"
-	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 
 	"golang.org/x/sys/unix"
@@ -19,15 +20,42 @@ type Acceptor struct {
 
 	reuseAddr        bool // SO_REUSEADDR
 	reusePort        bool // SO_REUSEPORT
+	ipv6Only         bool // IPV6_V6ONLY
 	sockRcvBufSize   int  // ignore equal 0
+	sockSndBufSize   int  // ignore equal 0
+	fastOpenQlen     int  // TCP_FASTOPEN
+	deferAccept      int  // TCP_DEFER_ACCEPT
+	incomingCPU      int  // SO_INCOMING_CPU, ignore < 0
+	reusePortCPU     int  // SO_ATTACH_REUSEPORT_CBPF group size
+	connOpts         connSockOpts
 	listenBacklog    int
 	loopAcceptTimes  int
+	tlsConfig        *tls.Config
+	tlsTimeout       int64
+	limiter          *acceptLimiter
+	proxyTimeout     int64  // millisecond, PROXY protocol is enabled if > 0
+	proxyMaxPending  int    // ProxyProtocolMaxPending
+	proxyPending     int    // Waiting for the PROXY protocol header, within the acceptor evpoll
+	unixType         int    // SOCK_STREAM or SOCK_SEQPACKET of unix socket, 0 for TCP
+	unixPath         string // The socket file, empty for the abstract socket
+	unixMode         os.FileMode
+	unixUID          int  // ignore < 0
+	unixGID          int  // ignore < 0
+	paused           bool // within the evpoll coroutine
+	reserveFd        bool
+	spareFd          int // /dev/null, released on EMFILE
+	rejectPayload    []byte
+	onEMFILE         func()
+	emfileCount      atomic.Uint64
 	newEvHanlderFunc func() EvHandler
 	reactor          *Reactor
 }
 
 // NewAcceptor return an acceptor
 //
+// If options.TLSConfig is set, newEvHanlderFunc is called once here to check that the EvHandler
+// embeds TLSHandle, the handler is discarded (mind the side effects, e.g. pools or counters)
+//
 // New socket has been set to non-blocking
 func NewAcceptor(acceptorBindReactor *Reactor, addr string,
 	newEvHanlderFunc func() EvHandler, opts ...Option) (*Acceptor, error) {
@@ -37,38 +65,87 @@ func NewAcceptor(acceptorBindReactor *Reactor, addr string,
 		newEvHanlderFunc: newEvHanlderFunc,
 		listenBacklog:    evOptions.listenBacklog,
 		sockRcvBufSize:   evOptions.sockRcvBufSize,
+		sockSndBufSize:   evOptions.sockSndBufSize,
+		fastOpenQlen:     evOptions.tcpFastOpenQlen,
+		deferAccept:      evOptions.tcpDeferAccept,
+		incomingCPU:      evOptions.incomingCPU,
+		reusePortCPU:     evOptions.reusePortCPUGroup,
 		reuseAddr:        evOptions.reuseAddr,
 		reusePort:        evOptions.reusePort,
+		ipv6Only:         evOptions.ipv6Only,
+		tlsConfig:        evOptions.tlsConfig,
+		tlsTimeout:       evOptions.tlsTimeout,
+		limiter:          newAcceptLimiter(&evOptions),
+		proxyTimeout:     evOptions.proxyProtoTimeout,
+		proxyMaxPending:  evOptions.proxyProtoPending,
+		reserveFd:        evOptions.acceptReserveFd,
+		spareFd:          -1,
+		rejectPayload:    evOptions.acceptRejectPayload,
+		onEMFILE:         evOptions.acceptEMFILECallback,
+		unixMode:         evOptions.unixSocketMode,
+		unixUID:          evOptions.unixSocketUID,
+		unixGID:          evOptions.unixSocketGID,
+		connOpts: connSockOpts{
+			noDelay:        evOptions.tcpNoDelay,
+			keepAliveIdle:  evOptions.tcpKeepAliveIdle,
+			keepAliveIntvl: evOptions.tcpKeepAliveIntvl,
+			keepAliveCnt:   evOptions.tcpKeepAliveCnt,
+		},
+	}
+	if a.tlsConfig != nil {
+		if _, ok := newEvHanlderFunc().(tlsHandler); !ok { // Discarded, refer to the doc
+			return nil, errors.New("NewAcceptor: the EvHandler must embed TLSHandle when options.TLSConfig is set")
+		}
+	}
+	if a.reusePortCPU > 0 && !a.reusePort {
+		return nil, errors.New("NewAcceptor: options.ReusePortSteerByCPU requires options.ReusePort(true)")
 	}
 	a.loopAcceptTimes = a.listenBacklog / 2
 	if a.loopAcceptTimes < 1 {
 		a.loopAcceptTimes = 1
 	}
+	if a.reserveFd {
+		fd, err := openSpareFd()
+		if err != nil {
+			return nil, errors.New("Open spare fd in NewAcceptor: " + err.Error())
+		}
+		a.spareFd = fd
+	}
 	if err := a.open(addr); err != nil {
+		if a.spareFd > 0 {
+			syscall.Close(a.spareFd)
+		}
 		return nil, err
 	}
 	return a, nil
 }
 
 // open create a listen fd
-// The addr format 192.168.0.1:8080 or :8080 or unix:/tmp/xxxx.sock
+// The addr format 192.168.0.1:8080 or :8080 or [::]:8080 or unix:/tmp/xxxx.sock
+// or unix:@name (Linux abstract socket) or unixpacket:/tmp/xxxx.sock (SOCK_SEQPACKET)
 func (a *Acceptor) open(addr string) error {
 	p := strings.Index(addr, ":")
 	if p < 0 || p >= (len(addr)-1) {
 		return errors.New("Accetor open param:addr invalid")
 	}
-	if len(addr) > 5 {
-		s := addr[0:5]
-		if s == "unix:" {
-			return a.udsListen(addr[5:])
-		}
+	if strings.HasPrefix(addr, "unix:") {
+		return a.udsListen(addr[5:], syscall.SOCK_STREAM)
+	} else if strings.HasPrefix(addr, "unixpacket:") {
+		return a.udsListen(addr[11:], syscall.SOCK_SEQPACKET)
 	}
 	return a.tcpListen(addr)
 }
 
-// The addr format 192.168.0.1:8080 or :8080
+// The addr format 192.168.0.1:8080 or :8080 or [::1]:8080
 func (a *Acceptor) tcpListen(addr string) error {
-	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	sa, domain, err := resolveListenAddr(addr)
+	if err != nil {
+		return err
+	}
+	if fd := takeInheritedListener(sa, syscall.SOCK_STREAM); fd > 0 {
+		return a.inherit(fd)
+	}
+	fd, err := syscall.Socket(domain, syscall.SOCK_STREAM, 0)
 	if err != nil {
 		return errors.New("Socket in Acceptor.open: " + err.Error())
 	}
@@ -85,6 +162,17 @@ func (a *Acceptor) tcpListen(addr string) error {
 			return errors.New("Set SO_REUSEPORT in Acceptor.open: " + err.Error())
 		}
 	}
+	if domain == syscall.AF_INET6 {
+		// Set it explicitly, don't depend on `sysctl net.ipv6.bindv6only`
+		v := 0
+		if a.ipv6Only == true {
+			v = 1
+		}
+		if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, v); err != nil {
+			syscall.Close(fd)
+			return errors.New("Set IPV6_V6ONLY in Acceptor.open: " + err.Error())
+		}
+	}
 	syscall.SetNonblock(fd, true)
 
 	if a.sockRcvBufSize > 0 {
@@ -99,42 +187,59 @@ func (a *Acceptor) tcpListen(addr string) error {
 			return errors.New("Set SO_RCVBUF: " + err.Error())
 		}
 	}
-
-	ip := "0.0.0.0"
-	var port int64
-	ipp := strings.Split(addr, ":")
-	if len(ipp) != 2 {
-		syscall.Close(fd)
-		return errors.New("address is invalid! 192.168.1.1:80 or :80")
+	if a.sockSndBufSize > 0 {
+		// must < `sysctl -a | grep net.core.wmem_max`
+		err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, a.sockSndBufSize)
+		if err != nil {
+			syscall.Close(fd)
+			return errors.New("Set SO_SNDBUF: " + err.Error())
+		}
 	}
-	if len(ipp[0]) > 0 {
-		ip = ipp[0]
+	if a.fastOpenQlen > 0 {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_FASTOPEN, a.fastOpenQlen)
+		if err != nil {
+			syscall.Close(fd)
+			return errors.New("Set TCP_FASTOPEN: " + err.Error())
+		}
 	}
-	ip4 := net.ParseIP(ip)
-	if ip4 == nil {
-		syscall.Close(fd)
-		return errors.New("address is invalid! 192.168.1.1:80 or :80")
+	if a.deferAccept > 0 {
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, a.deferAccept)
+		if err != nil {
+			syscall.Close(fd)
+			return errors.New("Set TCP_DEFER_ACCEPT: " + err.Error())
+		}
 	}
-	port, _ = strconv.ParseInt(ipp[1], 10, 64)
-	if port < 1 || port > 65535 {
-		syscall.Close(fd)
-		return errors.New("port must in (0, 65536)")
+	if a.incomingCPU >= 0 {
+		err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_INCOMING_CPU, a.incomingCPU)
+		if err != nil {
+			syscall.Close(fd)
+			return errors.New("Set SO_INCOMING_CPU: " + err.Error())
+		}
 	}
-	sa := syscall.SockaddrInet4{Port: int(port)}
-	copy(sa.Addr[:], ip4.To4())
 
-	if err := a.listen(fd, &sa); err != nil {
+	if err := a.listen(fd, sa); err != nil {
 		syscall.Close(fd)
 		return err
 	}
 	return nil
 }
 
-// The addr format /tmp/xxx.sock
-func (a *Acceptor) udsListen(addr string) error {
-	os.RemoveAll(addr)
+// The addr format /tmp/xxx.sock or @name
+func (a *Acceptor) udsListen(addr string, typ int) error {
+	a.unixType = typ
+	abstract := addr[0] == '@' // No socket file, it's released when the socket is closed
+	if fd := takeInheritedListener(&syscall.SockaddrUnix{Name: addr}, typ); fd > 0 {
+		if !abstract {
+			a.unixPath = addr
+		}
+		return a.inherit(fd)
+	}
+	if !abstract {
+		os.RemoveAll(addr)
+		a.unixPath = addr
+	}
 
-	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	fd, err := syscall.Socket(syscall.AF_UNIX, typ, 0)
 	if err != nil {
 		return errors.New("Socket in Acceptor.open: " + err.Error())
 	}
@@ -145,25 +250,67 @@ func (a *Acceptor) udsListen(addr string) error {
 
 	rsu := syscall.SockaddrUnix{Name: addr}
 	if err = a.listen(fd, &rsu); err != nil {
-		os.RemoveAll(addr)
+		if !abstract {
+			os.RemoveAll(addr)
+		}
 		syscall.Close(fd)
 		return err
 	}
 	return nil
 }
 
+// chownUnixFile sets the mode/owner of the socket file before listen, so no client can connect
+// to it with the default permissions
+func (a *Acceptor) chownUnixFile() error {
+	if a.unixMode != 0 {
+		if err := os.Chmod(a.unixPath, a.unixMode); err != nil {
+			return errors.New("Chmod unix socket: " + err.Error())
+		}
+	}
+	if a.unixUID >= 0 || a.unixGID >= 0 {
+		if err := os.Chown(a.unixPath, a.unixUID, a.unixGID); err != nil {
+			return errors.New("Chown unix socket: " + err.Error())
+		}
+	}
+	return nil
+}
+
 func (a *Acceptor) listen(fd int, sa syscall.Sockaddr) error {
 	if err := syscall.Bind(fd, sa); err != nil {
 		return errors.New("syscall bind: " + err.Error())
 	}
+	if a.unixPath != "" {
+		if err := a.chownUnixFile(); err != nil {
+			return err
+		}
+	}
 	if err := syscall.Listen(fd, a.listenBacklog); err != nil {
 		return errors.New("syscall listen: " + err.Error())
 	}
+	if a.reusePortCPU > 0 {
+		// The group is formed by listen, the program is shared by all the sockets in the group
+		if err := attachReusePortCPUProg(fd, a.reusePortCPU); err != nil {
+			return errors.New("Set SO_ATTACH_REUSEPORT_CBPF: " + err.Error())
+		}
+	}
 
 	if err := a.reactor.AddEvHandler(a, fd, EvAccept); err != nil {
 		return errors.New("AddEvHandler in Acceptor.Open: " + err.Error())
 	}
 	a.setFd(fd)
+	addListener(a)
+	return nil
+}
+
+// inherit uses the listening fd passed by the parent process or systemd, refer to hot_restart.go
+func (a *Acceptor) inherit(fd int) error {
+	syscall.SetNonblock(fd, true)
+	if err := a.reactor.AddEvHandler(a, fd, EvAccept); err != nil {
+		syscall.Close(fd)
+		return errors.New("AddEvHandler in Acceptor.Open: " + err.Error())
+	}
+	a.setFd(fd)
+	addListener(a)
 	return nil
 }
 
@@ -171,36 +318,194 @@ func (a *Acceptor) listen(fd int, sa syscall.Sockaddr) error {
 func (a *Acceptor) OnRead() bool {
 	fd := a.Fd()
 	for i := 0; i < a.loopAcceptTimes; i++ {
-		conn, _, err := syscall.Accept4(fd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+		conn, sa, err := syscall.Accept4(fd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
 		if err != nil {
 			if err == syscall.EINTR {
 				continue
-			} else if err == syscall.EMFILE {
-				// The per-process limit on the number of open file descriptors has been reached
-				if a.ScheduleTimer(a, 100 /*msec*/, 0) == nil {
+			} else if err == syscall.EMFILE || err == syscall.ENFILE {
+				// The limit on the number of open file descriptors has been reached
+				a.emfileCount.Add(1)
+				if a.onEMFILE != nil {
+					a.onEMFILE()
+				}
+				if a.spareFd > 0 && a.rejectOne(fd) {
+					continue
+				}
+				if _, err := a.ScheduleTimer(a, 100 /*msec*/, 0); err == nil {
 					a.reactor.RemoveEvent(fd, EvAll)
 				}
 			}
 			break
 		}
-		h := a.newEvHanlderFunc()
-		h.setFd(conn)
-		if h.OnOpen() == false {
-			h.OnClose()
+		if a.proxyTimeout > 0 {
+			a.proxyProtoAccept(conn, sa)
+			continue
 		}
+		a.accepted(conn, sa, nil)
 	}
 	return true
 }
 
+// accepted creates the EvHandler of the new connection, sa is the real client address if
+// the PROXY protocol is enabled
+func (a *Acceptor) accepted(conn int, sa syscall.Sockaddr, ph *ProxyHeader) {
+	if a.unixType == 0 && !a.connOpts.empty() {
+		a.connOpts.apply(conn) // Before AcceptFilter, which may override them
+	}
+	var release func()
+	if a.limiter != nil {
+		var ok bool
+		if release, ok = a.limiter.admit(conn, sa, a.Now()); !ok {
+			syscall.Close(conn)
+			return
+		}
+	}
+	h := a.newEvHanlderFunc()
+	if a.tlsConfig != nil {
+		th, ok := h.(tlsHandler) // Checked by NewAcceptor
+		if !ok {
+			if release != nil {
+				release()
+			}
+			syscall.Close(conn)
+			return
+		}
+		th.InitTLS(a.tlsConfig, false)
+		th.setHandshakeTimeout(a.tlsTimeout)
+	}
+	h.setFd(conn)
+	if release != nil {
+		h.setReleaseFunc(release)
+	}
+	if ph != nil {
+		h.setProxyHeader(ph)
+	}
+	if h.OnOpen() == false {
+		h.OnClose()
+	}
+}
+
+// rejectOne releases the spare fd to accept the pending connection and closes it at once
+// (after sending the rejection payload), so the client doesn't hang in the backlog.
+// Return false if the spare fd can't be reopened
+func (a *Acceptor) rejectOne(fd int) bool {
+	syscall.Close(a.spareFd)
+	a.spareFd = -1
+	for {
+		conn, _, err := syscall.Accept4(fd, syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == nil {
+			if len(a.rejectPayload) > 0 {
+				syscall.Write(conn, a.rejectPayload) // Best effort
+			}
+			syscall.Close(conn)
+		}
+		break
+	}
+	a.spareFd, _ = openSpareFd()
+	return a.spareFd > 0
+}
+
+func openSpareFd() (int, error) {
+	return syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+}
+
+// EMFILECount returns how many times accept failed with EMFILE/ENFILE (too many open files)
+//
+// It is safe for concurrent use by multiple goroutines
+func (a *Acceptor) EMFILECount() uint64 {
+	return a.emfileCount.Load()
+}
+
 // OnTimeout readd to evpoll
 func (a *Acceptor) OnTimeout(millisecond int64) bool {
-	if a.Fd() > 0 {
-		a.reactor.AddEvHandler(a, a.Fd(), EvAccept)
+	fd := a.Fd()
+	if fd < 1 { // Closed, don't reopen the spare fd
+		return false
+	}
+	if a.reserveFd && a.spareFd < 0 {
+		a.spareFd, _ = openSpareFd()
+	}
+	a.getEvPoll().add(fd, EvAccept, a) // Keep in the same evPoll
+	if a.paused {
+		a.getEvPoll().disableRead(fd)
 	}
 	return false
 }
 
-// OnClose will not happen
+// Pause stops accepting new connections, the listen fd is kept (the connections are queued in
+// the backlog by the kernel until Resume)
+//
+// It is safe for concurrent use by multiple goroutines
+func (a *Acceptor) Pause() error {
+	return a.Post(func() {
+		if fd := a.Fd(); fd > 0 && !a.paused {
+			a.paused = true
+			a.getEvPoll().disableRead(fd) // Fails in the EMFILE backoff, OnTimeout handles it
+		}
+	})
+}
+
+// Resume starts accepting again after Pause
+//
+// It is safe for concurrent use by multiple goroutines
+func (a *Acceptor) Resume() error {
+	return a.Post(func() {
+		if fd := a.Fd(); fd > 0 && a.paused {
+			a.paused = false
+			a.getEvPoll().enableRead(fd)
+		}
+	})
+}
+
+// Close stops listening and closes the listen fd (OnClose is called within the evpoll coroutine),
+// the socket file of `unix:' listener is removed. The accepted connections are not affected.
+//
+// Reactor.Stop/Shutdown close it too but keep the socket file (it may be inherited by the new process)
+//
+// It is safe for concurrent use by multiple goroutines
+func (a *Acceptor) Close() error {
+	return a.Post(func() {
+		if a.Fd() < 1 {
+			return
+		}
+		a.closeInPoll()
+		if a.unixPath != "" {
+			os.Remove(a.unixPath)
+		}
+	})
+}
+
+// Addr returns the bound address of the listener, *net.TCPAddr (the port chosen by the kernel
+// if it listens on port 0) or *net.UnixAddr (Net is "unixpacket" for SOCK_SEQPACKET, Name starts
+// with '@' for the abstract socket), return nil if it has been closed
+//
+// It is safe for concurrent use by multiple goroutines
+func (a *Acceptor) Addr() net.Addr {
+	fd := a.Fd()
+	if fd < 1 {
+		return nil
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return nil
+	}
+	addr := sockaddrToAddr(sa)
+	if ua, ok := addr.(*net.UnixAddr); ok && a.unixType == syscall.SOCK_SEQPACKET {
+		ua.Net = "unixpacket"
+	}
+	return addr
+}
+
+// OnClose called by Close or Reactor.Stop/Shutdown, releases the listen fd
 func (a *Acceptor) OnClose() {
+	a.CancelTimer(a) // The EMFILE backoff
+	if a.spareFd > 0 {
+		syscall.Close(a.spareFd)
+		a.spareFd = -1
+	}
+	delListener(a)
 	a.Destroy(a)
 }
@@ -0,0 +1,679 @@
+package goev
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAcceptLimiter(t *testing.T) {
+	o := setOptions(AcceptMaxConns(3), AcceptMaxConnsPerIP(2, 24, 64), AcceptRateLimit(1000, 4))
+	l := newAcceptLimiter(&o)
+	sa := func(a, b, c, d byte) syscall.Sockaddr {
+		return &syscall.SockaddrInet4{Addr: [4]byte{a, b, c, d}}
+	}
+	mapped := &syscall.SockaddrInet6{Addr: [16]byte{10: 0xff, 11: 0xff, 12: 10, 13: 0, 14: 0, 15: 9}}
+
+	var releases []func()
+	for i, c := range []struct {
+		sa syscall.Sockaddr
+		ok bool
+	}{
+		{sa(10, 0, 0, 1), true},
+		{mapped, true},                   // The same /24 as 10.0.0.1
+		{sa(10, 0, 0, 2), false},         // Per CIDR
+		{sa(10, 0, 1, 1), true},          // Another /24
+		{sa(10, 0, 2, 1), false},         // Max conns
+		{&syscall.SockaddrUnix{}, false}, // Max conns
+	} {
+		release, ok := l.admit(0, c.sa, 0)
+		if ok != c.ok {
+			t.Fatalf("#%d admit %v", i, ok)
+		}
+		if ok {
+			releases = append(releases, release)
+		}
+	}
+	releases[0]()
+	// The 4th token is left, the rejected connections don't take tokens
+	if _, ok := l.admit(0, sa(10, 0, 0, 3), 0); !ok {
+		t.Fatal("counter not decremented")
+	}
+	if len(l.perIP) != 2 || l.conns != 3 {
+		t.Fatalf("perIP %v, conns %d", l.perIP, l.conns)
+	}
+	releases[1]()
+	if _, ok := l.admit(0, sa(10, 0, 3, 1), 0); ok {
+		t.Fatal("admitted without token")
+	}
+	if _, ok := l.admit(0, sa(10, 0, 3, 1), 1000); !ok {
+		t.Fatal("tokens not refilled")
+	}
+}
+
+type limitConn struct {
+	IOHandle
+
+	r *Reactor
+}
+
+func (c *limitConn) OnOpen() bool {
+	return c.r.AddEvHandler(c, c.Fd(), EvIn) == nil
+}
+func (c *limitConn) OnRead() bool {
+	_, n, err := c.Read()
+	return !(n == 0 || (n < 0 && err != syscall.EAGAIN))
+}
+func (c *limitConn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestAcceptorLimit(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filtered atomic.Int32
+	a, err := NewAcceptor(r, "127.0.0.1:8098", func() EvHandler { return &limitConn{r: r} },
+		AcceptMaxConnsPerIP(1, 32, 128),
+		AcceptFilter(func(fd int, sa syscall.Sockaddr) bool {
+			filtered.Add(1)
+			_, ok := sa.(*syscall.SockaddrInet4)
+			return ok
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	waitConnNum := func(n int) {
+		for i := 0; i < 100 && a.ConnNum() != n; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if a.ConnNum() != n {
+			t.Fatalf("ConnNum %d, want %d", a.ConnNum(), n)
+		}
+	}
+	c1, err := net.Dial("tcp", "127.0.0.1:8098")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitConnNum(1)
+
+	c2, err := net.Dial("tcp", "127.0.0.1:8098")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = c2.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+		t.Fatal("the connection over the limit is not closed")
+	}
+	c2.Close()
+
+	c1.Close()
+	waitConnNum(0)
+	c3, err := net.Dial("tcp", "127.0.0.1:8098")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c3.Close()
+	waitConnNum(1)
+	if filtered.Load() != 3 {
+		t.Fatalf("AcceptFilter called %d times", filtered.Load())
+	}
+}
+
+type proxyConn struct {
+	IOHandle
+
+	r  *Reactor
+	ch chan string
+}
+
+func (c *proxyConn) OnOpen() bool {
+	ph := c.ProxyHeader()
+	if ph == nil || ph.SrcAddr == nil {
+		c.ch <- "no header"
+		return false
+	}
+	src := ph.SrcAddr.(*syscall.SockaddrInet4)
+	c.ch <- fmt.Sprintf("v%d %v:%d %d", ph.Version, net.IP(src.Addr[:]), src.Port, len(ph.TLVs))
+	return c.r.AddEvHandler(c, c.Fd(), EvIn) == nil
+}
+func (c *proxyConn) OnRead() bool {
+	buf, n, err := c.Read()
+	if n == 0 || (n < 0 && err != syscall.EAGAIN) {
+		return false
+	}
+	if n > 0 {
+		c.ch <- string(buf[:n])
+	}
+	return true
+}
+func (c *proxyConn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	for _, c := range []struct {
+		hdr string
+		ok  bool
+	}{
+		{"PROXY TCP6 ::1 ::2 1 2\r\n", true},
+		{"PROXY UNKNOWN\r\n", true},
+		{"PROXY TCP4 1.2.3.4 ::1 1 2\r\n", false},
+		{"PROXY TCP6 1.2.3.4 ::1 1 2\r\n", false},
+		{"PROXY TCP4 1.2.3.4 5.6.7.8 1\r\n", false},
+		{"\r\n\r\n\x00\r\nQUIT\n\x20\x00\x00\x00", true}, // LOCAL
+		{"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x01\x00", false},
+	} {
+		ph, done, err := parseProxyHeader([]byte(c.hdr))
+		if (err == nil && done) != c.ok || (c.ok && ph.Local != (ph.SrcAddr == nil)) {
+			t.Fatalf("%q: %v %v", c.hdr, done, err)
+		}
+	}
+
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan string, 8)
+	_, err = NewAcceptor(r, "127.0.0.1:8099", func() EvHandler { return &proxyConn{r: r, ch: ch} },
+		ProxyProtocol(200), ProxyProtocolMaxPending(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	expect := func(s string) {
+		select {
+		case v := <-ch:
+			if v != s {
+				t.Fatalf("got %q, want %q", v, s)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q timeout", s)
+		}
+	}
+	dial := func(data ...string) net.Conn {
+		conn, err := net.Dial("tcp", "127.0.0.1:8099")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range data {
+			conn.Write([]byte(d))
+			time.Sleep(20 * time.Millisecond)
+		}
+		return conn
+	}
+	expectClosed := func(conn net.Conn) {
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err == nil || os.IsTimeout(err) {
+			t.Fatal("connection not closed")
+		}
+	}
+
+	// The data following the header must be left for the handler
+	conn := dial("PROXY TCP4 1.2.3.4 127.0.0.1 5678 8080\r\nhello")
+	expect("v1 1.2.3.4:5678 0")
+	expect("hello")
+	conn.Close()
+
+	v2 := "\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x13" + // PROXY, TCP over IPv4, len 19
+		"\x01\x02\x03\x04\x7f\x00\x00\x01\x16\x2e\x1f\x90" + // 1.2.3.4:5678 -> 127.0.0.1:8080
+		"\x02\x00\x04host" // PP2_TYPE_AUTHORITY
+	conn = dial(v2[:7], v2[7:20], v2[20:]+"hello")
+	expect("v2 1.2.3.4:5678 1")
+	expect("hello")
+	conn.Close()
+
+	expectClosed(dial("GET / HTTP/1.1\r\n"))
+	expectClosed(dial()) // Timeout
+
+	// Only one connection can wait for the header
+	pending := dial()
+	start := time.Now()
+	expectClosed(dial())
+	if time.Since(start) > 150*time.Millisecond {
+		t.Fatal("pending connections not limited")
+	}
+	expectClosed(pending)
+	if len(ch) != 0 {
+		t.Fatal("invalid connection accepted")
+	}
+}
+
+type helloConn struct {
+	IOHandle
+
+	hello string
+}
+
+func (c *helloConn) OnOpen() bool {
+	c.Write([]byte(c.hello))
+	return false
+}
+func (c *helloConn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestHotRestart(t *testing.T) {
+	if os.Getenv(envListenFds) != "" { // The new process
+		r, err := NewReactor()
+		if err != nil {
+			t.Fatal(err)
+		}
+		served := make(chan struct{})
+		a, err := NewAcceptor(r, "127.0.0.1:8100", func() EvHandler {
+			defer close(served)
+			return &helloConn{hello: "new"}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Fd() != listenFdsStart {
+			t.Fatalf("listener not inherited, fd %d", a.Fd())
+		}
+		inherited.mtx.Lock()
+		n := len(inherited.fds)
+		inherited.mtx.Unlock()
+		if n != 0 { // The listener of the other reactor is not passed
+			t.Fatalf("%d listeners not taken", n)
+		}
+		CloseInheritedListeners()
+		if os.Getenv("LISTEN_ADDR") != "127.0.0.1:8100" {
+			t.Fatal("user env stripped")
+		}
+		go r.Run()
+		<-served
+		r.Shutdown(context.Background())
+		return
+	}
+
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAcceptor(r, "127.0.0.1:8100", func() EvHandler { return &helloConn{hello: "old"} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	r2, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAcceptor(r2, "127.0.0.1:0", func() EvHandler { return &helloConn{hello: "r2"} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r2.Run()
+	defer r2.Stop()
+	os.Setenv("LISTEN_ADDR", "127.0.0.1:8100")
+	defer os.Unsetenv("LISTEN_ADDR")
+	hello := func() string {
+		conn, err := net.Dial("tcp", "127.0.0.1:8100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b, _ := io.ReadAll(conn)
+		return string(b)
+	}
+	if s := hello(); s != "old" {
+		t.Fatalf("hello %q", s)
+	}
+
+	p, err := r.ForkExec(os.Args[0], "-test.run=^TestHotRestart$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The old process has stopped accepting, the connection is accepted by the new one
+	if s := hello(); s != "new" {
+		t.Fatalf("hello %q", s)
+	}
+	if st, err := p.Wait(); err != nil || !st.Success() {
+		t.Fatalf("new process exited: %v %v", st, err)
+	}
+}
+
+func TestInheritedFdsEnv(t *testing.T) {
+	if os.Getenv(envListenFds) != "" {
+		t.Skip("the new process of TestHotRestart")
+	}
+	pid := strconv.Itoa(os.Getpid() + 1) // Targets another process
+	t.Setenv("LISTEN_PID", pid)
+	t.Setenv("LISTEN_FDS", "1")
+	loadInheritedFds()
+	if os.Getenv("LISTEN_PID") != pid || os.Getenv("LISTEN_FDS") != "1" {
+		t.Fatal("the env of another process is unset")
+	}
+	inherited.mtx.Lock()
+	n := len(inherited.fds)
+	inherited.mtx.Unlock()
+	if n != 0 {
+		t.Fatalf("%d fds inherited", n)
+	}
+}
+
+func TestAcceptorLifecycle(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAcceptor(r, "127.0.0.1:0", func() EvHandler { return &helloConn{hello: "hi"} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "goev.sock")
+	ua, err := NewAcceptor(r, "unix:"+sock, func() EvHandler { return &helloConn{hello: "hi"} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	addr := a.Addr().(*net.TCPAddr)
+	if addr.Port == 0 || ua.Addr().String() != sock {
+		t.Fatalf("Addr %s %s", addr, ua.Addr())
+	}
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	b := make([]byte, 2)
+	if _, err = io.ReadFull(conn, b); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Pause()
+	time.Sleep(20 * time.Millisecond)
+	conn2, err := net.Dial("tcp", addr.String()) // Queued in the backlog
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	conn2.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err = conn2.Read(b); !os.IsTimeout(err) {
+		t.Fatal("accepted while paused")
+	}
+	a.Resume()
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = io.ReadFull(conn2, b); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Close()
+	ua.Close()
+	time.Sleep(20 * time.Millisecond)
+	if _, err = net.Dial("tcp", addr.String()); err == nil {
+		t.Fatal("still listening after Close")
+	}
+	if _, err = os.Stat(sock); !os.IsNotExist(err) {
+		t.Fatal("unix socket file not removed")
+	}
+	if a.Addr() != nil {
+		t.Fatal("Addr of closed acceptor")
+	}
+}
+
+func TestAcceptorReserveFd(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var emfile atomic.Int32
+	a, err := NewAcceptor(r, "127.0.0.1:0", func() EvHandler { return &helloConn{hello: "hi"} },
+		AcceptReserveFd([]byte("busy")), AcceptEMFILECallback(func() { emfile.Add(1) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	addr := a.Addr().String()
+	hello := func() string {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b, _ := io.ReadAll(conn)
+		return string(b)
+	}
+	if s := hello(); s != "hi" { // The netpoller of the client is initialized
+		t.Fatalf("hello %q", s)
+	}
+
+	// Exhaust the descriptors, keep one for the client
+	var rlim syscall.Rlimit
+	if err = syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		t.Fatal(err)
+	}
+	low := rlim
+	low.Cur = 512
+	if low.Cur > rlim.Cur {
+		t.Skip("RLIMIT_NOFILE too small")
+	}
+	if err = syscall.Setrlimit(syscall.RLIMIT_NOFILE, &low); err != nil {
+		t.Skip("Setrlimit: " + err.Error())
+	}
+	var hogs []int
+	for {
+		fd, err := syscall.Open("/dev/null", syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			break
+		}
+		hogs = append(hogs, fd)
+	}
+	syscall.Close(hogs[len(hogs)-1])
+	hogs = hogs[:len(hogs)-1]
+	s := hello()
+	for _, fd := range hogs {
+		syscall.Close(fd)
+	}
+	syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rlim)
+
+	if s != "busy" || emfile.Load() == 0 || a.EMFILECount() != uint64(emfile.Load()) {
+		t.Fatalf("hello %q, EMFILE %d", s, emfile.Load())
+	}
+	if s = hello(); s != "hi" {
+		t.Fatalf("hello %q after EMFILE", s)
+	}
+}
+
+type sockOptConn struct {
+	IOHandle
+
+	r    *Reactor
+	opts chan [3]int
+}
+
+func (c *sockOptConn) OnOpen() bool {
+	noDelay, _ := syscall.GetsockoptInt(c.Fd(), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	keepAlive, _ := syscall.GetsockoptInt(c.Fd(), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+	idle, _ := syscall.GetsockoptInt(c.Fd(), syscall.IPPROTO_TCP, unix.TCP_KEEPIDLE)
+	c.opts <- [3]int{noDelay, keepAlive, idle}
+	return c.r.AddEvHandler(c, c.Fd(), EvIn) == nil
+}
+func (c *sockOptConn) OnRead() bool {
+	buf, n, _ := c.Read()
+	if n < 1 {
+		return n < 0
+	}
+	c.Write(buf[:n]) // Echo
+	return true
+}
+func (c *sockOptConn) OnClose() {
+	c.Destroy(c)
+}
+
+type fastOpenConn struct {
+	IOHandle
+
+	r    *Reactor
+	echo chan string
+}
+
+func (c *fastOpenConn) OnOpen() bool {
+	return c.r.AddEvHandler(c, c.Fd(), EvIn) == nil
+}
+func (c *fastOpenConn) OnRead() bool {
+	buf, n, _ := c.Read()
+	if n > 0 {
+		c.echo <- string(buf[:n])
+	}
+	return false
+}
+func (c *fastOpenConn) OnConnectFail(err error) {
+	c.echo <- err.Error()
+}
+func (c *fastOpenConn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestAcceptorSockOpts(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := make(chan [3]int, 4)
+	a, err := NewAcceptor(r, "127.0.0.1:0", func() EvHandler { return &sockOptConn{r: r, opts: opts} },
+		ReusePort(true), ReusePortSteerByCPU(1), IncomingCPU(0), SockSndBufSize(64*1024),
+		TCPFastOpen(16), TCPDeferAccept(1), TCPNoDelay(true), TCPKeepAlive(30, 5, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := NewConnector(r)
+	go r.Run()
+	defer r.Stop()
+
+	_, err = NewAcceptor(r, "127.0.0.1:0", func() EvHandler { return &sockOptConn{r: r, opts: opts} },
+		ReusePortSteerByCPU(1))
+	if err == nil {
+		t.Fatal("ReusePortSteerByCPU without ReusePort")
+	}
+
+	for _, o := range []struct {
+		level, opt, v int
+	}{
+		{syscall.IPPROTO_TCP, unix.TCP_FASTOPEN, 16},
+		{syscall.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, 1},
+		{syscall.SOL_SOCKET, unix.SO_INCOMING_CPU, 0},
+	} {
+		if v, err := syscall.GetsockoptInt(a.Fd(), o.level, o.opt); err != nil || v < o.v {
+			t.Fatalf("listener option %d = %d %v", o.opt, v, err)
+		}
+	}
+
+	for i := 0; i < 2; i++ { // The 1st requests the TFO cookie
+		echo := make(chan string, 1)
+		err = c.ConnectData(a.Addr().String(), &fastOpenConn{r: r, echo: echo}, 1000, []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case s := <-echo:
+			if s != "hello" {
+				t.Fatalf("echo %q", s)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("ConnectData timeout")
+		}
+		if o := <-opts; o != [3]int{1, 1, 30} {
+			t.Fatalf("accepted fd options %v", o)
+		}
+	}
+}
+
+type credConn struct {
+	IOHandle
+}
+
+func (c *credConn) OnOpen() bool {
+	cred, err := c.PeerCred()
+	if err != nil {
+		c.Write([]byte(err.Error()))
+	} else {
+		c.Write([]byte(fmt.Sprintf("%d %d %d", cred.Pid, cred.Uid, cred.Gid)))
+	}
+	return false
+}
+func (c *credConn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestUnixSocket(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abstract := fmt.Sprintf("@goev-test-%d", os.Getpid())
+	aa, err := NewAcceptor(r, "unix:"+abstract, func() EvHandler { return &helloConn{hello: "hi"} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "goev.sock")
+	_, err = NewAcceptor(r, "unix:"+sock, func() EvHandler { return &helloConn{hello: "hi"} },
+		UnixSocketMode(0600), UnixSocketOwner(-1, os.Getgid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	psock := filepath.Join(t.TempDir(), "goev.psock")
+	pa, err := NewAcceptor(r, "unixpacket:"+psock, func() EvHandler { return &credConn{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	if aa.Addr().String() != abstract || pa.Addr().Network() != "unixpacket" {
+		t.Fatalf("Addr %s %s", aa.Addr(), pa.Addr().Network())
+	}
+	if fi, err := os.Stat(sock); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket file %v %v", fi.Mode(), err)
+	}
+	read := func(network, addr string) string {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 64)
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b[:n])
+	}
+	if s := read("unix", abstract); s != "hi" {
+		t.Fatalf("abstract %q", s)
+	}
+	if s := read("unix", sock); s != "hi" {
+		t.Fatalf("unix %q", s)
+	}
+	cred := fmt.Sprintf("%d %d %d", os.Getpid(), os.Getuid(), os.Getgid())
+	if s := read("unixpacket", psock); s != cred {
+		t.Fatalf("PeerCred %q, want %q", s, cred)
+	}
+}
@@ -14,10 +14,26 @@ import (
 
 // Asynchronously written data block.
 // The framework will ensure that it is sent out in the order in which it was enqueued
+//
+// If fn is not nil, it's a closure posted by Post/PostTo, and will be called in the same order
 type asyncWriteItem struct {
 	fd  int
 	eh  EvHandler
 	abf asyncWriteBuf
+	fn  func()
+}
+
+func (awi *asyncWriteItem) do() {
+	if awi.fn != nil {
+		awi.fn()
+		return
+	}
+	awi.eh.asyncOrderedWrite(awi.eh, awi.abf)
+}
+func (awi *asyncWriteItem) discard() {
+	if awi.fn == nil {
+		awi.abf.release(awi.eh)
+	}
 }
 
 // Using a double buffer queue, the 'writeq' is only responsible for receiving data blocks.
@@ -52,28 +68,82 @@ func newAsyncWrite(ep *evPoll) (*asyncWrite, error) {
 
 	return a, nil
 }
-func (aw *asyncWrite) push(awi asyncWriteItem) {
+
+// push return false if the evPoll has been closed (awi is discarded)
+func (aw *asyncWrite) push(awi asyncWriteItem) bool {
 	aw.mtx.Lock()
+	defer aw.mtx.Unlock()
+	if aw.efd < 0 { // evPoll closed
+		awi.discard()
+		return false
+	}
 	aw.writeq.PushBack(awi)
-	aw.mtx.Unlock()
 
 	if !aw.notified.CompareAndSwap(0, 1) {
-		return
+		return true
 	}
 	var v int64 = 1
 	for {
+		// Notify within the lock so that efd can't be closed and reused at the same time
 		_, err := syscall.Write(aw.efd, (*(*[8]byte)(unsafe.Pointer(&v)))[:]) // man 2 eventfd
 		if err != nil && err == syscall.EINTR {
 			continue
 		}
 		break
 	}
+	return true
+}
+
+// flush processes all queued items at once, used on shutdown
+func (aw *asyncWrite) flush() {
+	for {
+		if aw.readq.IsEmpty() {
+			aw.mtx.Lock()
+			aw.writeq, aw.readq = aw.readq, aw.writeq // Swap read/write queues
+			aw.mtx.Unlock()
+		}
+		item, ok := aw.readq.PopFront()
+		if !ok {
+			break
+		}
+		item.do()
+	}
+}
+
+// close called when evPoll is closed, the items that have not been processed are discarded
+func (aw *asyncWrite) close() {
+	aw.mtx.Lock()
+	syscall.Close(aw.efd)
+	aw.efd = -1
+	aw.writeq, aw.readq = aw.readq, aw.writeq
+	aw.mtx.Unlock()
+
+	for !aw.readq.IsEmpty() {
+		item, _ := aw.readq.PopFront()
+		item.discard()
+	}
 }
 
 // OnRead writeq has data
 func (aw *asyncWrite) OnRead() bool {
 	if aw.readq.IsEmpty() {
+		// Reset the notification before swapping, so the items pushed during processing
+		// (e.g. Post within a posted fn) will notify again
+		var bf [8]byte
+		for {
+			_, err := syscall.Read(aw.efd, bf[:])
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				} else if err != syscall.EAGAIN {
+					fmt.Fprintf(os.Stderr, "goev: eventfd read fail! "+err.Error())
+					// return false // TODO add evOptions.debug? panic("Notify: read eventfd failed!")
+				}
+			}
+			break
+		}
 		aw.mtx.Lock()
+		aw.notified.Store(0)
 		aw.writeq, aw.readq = aw.readq, aw.writeq // Swap read/write queues
 		aw.mtx.Unlock()
 	}
@@ -83,27 +153,22 @@ func (aw *asyncWrite) OnRead() bool {
 		if !ok {
 			break
 		}
-		item.eh.asyncOrderedWrite(item.eh, item.abf)
+		item.do()
 	}
 
-	if !aw.readq.IsEmpty() { // Ignore readable eventfd, continue
-		return true
-	}
-
-	var bf [8]byte
-	for {
-		_, err := syscall.Read(aw.efd, bf[:])
-		if err != nil {
-			if err == syscall.EINTR {
-				continue
-			} else if err == syscall.EAGAIN {
-				return true
+	if !aw.readq.IsEmpty() { // Continue in the next round
+		aw.mtx.Lock()
+		if aw.efd > 0 && aw.notified.CompareAndSwap(0, 1) {
+			var v int64 = 1
+			for {
+				_, err := syscall.Write(aw.efd, (*(*[8]byte)(unsafe.Pointer(&v)))[:])
+				if err != nil && err == syscall.EINTR {
+					continue
+				}
+				break
 			}
-			fmt.Fprintf(os.Stderr, "goev: eventfd read fail! "+err.Error())
-			// return false // TODO add evOptions.debug? panic("Notify: read eventfd failed!")
 		}
-		aw.notified.Store(0)
-		break
+		aw.mtx.Unlock()
 	}
 	return true
 }
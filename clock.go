@@ -0,0 +1,39 @@
+package goev
+
+import (
+	"time"
+)
+
+// Clock is the time source of evPoll (the cached time and the timers), the default is the
+// system clock. A fake clock can be set by options.EvPollClock to drive the timers
+// deterministically in tests
+type Clock interface {
+	// UnixMilli returns the current unix millisecond
+	UnixMilli() int64
+}
+
+type sysClock struct{}
+
+func (sysClock) UnixMilli() int64 {
+	return time.Now().UnixMilli()
+}
+
+// evClock caches the time within evPoll, it's refreshed after every epoll_wait returns
+// (and by the timer of options.EvPollCacheTimePeriod), so the handlers of a batch of events
+// see the same time without calling time.Now()
+type evClock struct {
+	clock Clock
+	now   int64 // unix millisecond
+}
+
+func newEvClock(clock Clock) *evClock {
+	if clock == nil {
+		clock = sysClock{}
+	}
+	return &evClock{clock: clock, now: clock.UnixMilli()}
+}
+
+func (c *evClock) update() int64 {
+	c.now = c.clock.UnixMilli()
+	return c.now
+}
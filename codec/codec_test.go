@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func testCodec(t *testing.T, name string, c Codec, msgs []string) {
+	var stream []byte
+	for _, m := range msgs {
+		var err error
+		if stream, err = c.Encode(stream, []byte(m)); err != nil {
+			t.Fatalf("%s: encode %s", name, err.Error())
+		}
+	}
+	// Feed the stream in every possible chunk size
+	for chunk := 1; chunk <= len(stream); chunk++ {
+		d := NewDecoder(c)
+		var got []string
+		for i := 0; i < len(stream); i += chunk {
+			end := i + chunk
+			if end > len(stream) {
+				end = len(stream)
+			}
+			err := d.Decode(stream[i:end], func(msg []byte) bool {
+				got = append(got, string(msg))
+				return true
+			})
+			if err != nil {
+				t.Fatalf("%s: decode %s", name, err.Error())
+			}
+		}
+		if len(got) != len(msgs) || d.Buffered() != 0 {
+			t.Fatalf("%s chunk %d: got %d frames, buffered %d", name, chunk, len(got), d.Buffered())
+		}
+		for i := range msgs {
+			if got[i] != msgs[i] {
+				t.Fatalf("%s chunk %d: frame %q != %q", name, chunk, got[i], msgs[i])
+			}
+		}
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	msgs := []string{"hello", "", "goev", "0123456789"}
+	testCodec(t, "uint16be", LengthField(2, binary.BigEndian, 64), msgs)
+	testCodec(t, "uint32le", LengthField(4, binary.LittleEndian, 64), msgs)
+	testCodec(t, "delimiter", Delimiter([]byte("||"), 64), msgs)
+	testCodec(t, "line", Line(64), msgs)
+	testCodec(t, "fixed", FixedLength(4), []string{"abcd", "efgh", "ijkl"})
+}
+
+func TestCodecErrors(t *testing.T) {
+	d := NewDecoder(LengthField(2, binary.BigEndian, 4))
+	if err := d.Decode([]byte{0, 5, 'a'}, func([]byte) bool { return true }); err != ErrFrameTooLarge {
+		t.Fatal("LengthField: frame too large expected")
+	}
+	d = NewDecoder(Line(4))
+	if err := d.Decode([]byte("abcdefgh"), func([]byte) bool { return true }); err != ErrFrameTooLarge {
+		t.Fatal("Line: frame too large expected")
+	}
+	d = NewDecoder(Line(4))
+	if err := d.Decode([]byte("abcd\r\n"), func([]byte) bool { return true }); err != nil {
+		t.Fatal("Line: frame with CRLF should be valid")
+	}
+	if _, err := FixedLength(4).Encode(nil, []byte("abc")); err != ErrInvalidFrame {
+		t.Fatal("FixedLength: invalid frame expected")
+	}
+
+	// Stop decoding and keep the rest
+	d = NewDecoder(FixedLength(2))
+	n := 0
+	d.Decode([]byte("aabbcc"), func([]byte) bool { n++; return false })
+	if n != 1 || d.Buffered() != 4 {
+		t.Fatalf("stop: frames %d, buffered %d", n, d.Buffered())
+	}
+}
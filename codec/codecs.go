@@ -0,0 +1,136 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+type lengthField struct {
+	size         int
+	order        binary.ByteOrder
+	maxFrameSize int
+}
+
+// LengthField returns a codec of length-prefixed frames: | length(size bytes) | payload |,
+// the length doesn't include itself.
+//
+// size is 2 (uint16) or 4 (uint32), order is binary.BigEndian or binary.LittleEndian,
+// maxFrameSize limits the payload size
+func LengthField(size int, order binary.ByteOrder, maxFrameSize int) Codec {
+	if (size != 2 && size != 4) || order == nil || maxFrameSize < 1 {
+		panic("codec: LengthField params are illegal")
+	}
+	return &lengthField{size: size, order: order, maxFrameSize: maxFrameSize}
+}
+
+func (c *lengthField) Split(data []byte) (int, []byte, error) {
+	if len(data) < c.size {
+		return 0, nil, nil
+	}
+	var n int
+	if c.size == 2 {
+		n = int(c.order.Uint16(data))
+	} else {
+		n = int(c.order.Uint32(data))
+	}
+	if n > c.maxFrameSize || n < 0 {
+		return 0, nil, ErrFrameTooLarge
+	}
+	if len(data) < c.size+n {
+		return 0, nil, nil
+	}
+	return c.size + n, data[c.size : c.size+n], nil
+}
+
+func (c *lengthField) Encode(dst, msg []byte) ([]byte, error) {
+	if len(msg) > c.maxFrameSize {
+		return dst, ErrFrameTooLarge
+	}
+	var hdr [4]byte
+	if c.size == 2 {
+		c.order.PutUint16(hdr[:], uint16(len(msg)))
+	} else {
+		c.order.PutUint32(hdr[:], uint32(len(msg)))
+	}
+	dst = append(dst, hdr[:c.size]...)
+	return append(dst, msg...), nil
+}
+
+type delimiter struct {
+	delim        []byte
+	trimCR       bool
+	maxFrameSize int
+}
+
+// Delimiter returns a codec of delimiter-terminated frames, the frame doesn't include the delimiter.
+// maxFrameSize limits the frame size
+func Delimiter(delim []byte, maxFrameSize int) Codec {
+	if len(delim) == 0 || maxFrameSize < 1 {
+		panic("codec: Delimiter params are illegal")
+	}
+	return &delimiter{delim: append([]byte(nil), delim...), maxFrameSize: maxFrameSize}
+}
+
+// Line returns a codec of text lines, the frame is terminated by "\n" or "\r\n"
+// (excluded in the frame). Encode appends "\r\n"
+func Line(maxFrameSize int) Codec {
+	if maxFrameSize < 1 {
+		panic("codec: Line params are illegal")
+	}
+	return &delimiter{delim: []byte{'\n'}, trimCR: true, maxFrameSize: maxFrameSize}
+}
+
+func (c *delimiter) Split(data []byte) (int, []byte, error) {
+	i := bytes.Index(data, c.delim)
+	if i < 0 {
+		if len(data) > c.maxFrameSize+len(c.delim) {
+			return 0, nil, ErrFrameTooLarge
+		}
+		return 0, nil, nil
+	}
+	frame := data[:i]
+	if c.trimCR && i > 0 && frame[i-1] == '\r' {
+		frame = frame[:i-1]
+	}
+	if len(frame) > c.maxFrameSize {
+		return 0, nil, ErrFrameTooLarge
+	}
+	return i + len(c.delim), frame, nil
+}
+
+func (c *delimiter) Encode(dst, msg []byte) ([]byte, error) {
+	if len(msg) > c.maxFrameSize {
+		return dst, ErrFrameTooLarge
+	}
+	dst = append(dst, msg...)
+	if c.trimCR {
+		dst = append(dst, '\r')
+	}
+	return append(dst, c.delim...), nil
+}
+
+type fixedLength struct {
+	size int
+}
+
+// FixedLength returns a codec of fixed-size frames
+func FixedLength(size int) Codec {
+	if size < 1 {
+		panic("codec: FixedLength param is illegal")
+	}
+	return &fixedLength{size: size}
+}
+
+func (c *fixedLength) Split(data []byte) (int, []byte, error) {
+	if len(data) < c.size {
+		return 0, nil, nil
+	}
+	return c.size, data[:c.size], nil
+}
+
+func (c *fixedLength) Encode(dst, msg []byte) ([]byte, error) {
+	if len(msg) != c.size {
+		return dst, ErrInvalidFrame
+	}
+	return append(dst, msg...), nil
+}
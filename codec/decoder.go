@@ -0,0 +1,142 @@
+// Package codec provides the framing codecs for goev connections, the decoder accumulates the
+// partial packets of a connection (the data returned by IOHandle.Read is overwritten on the
+// next read) and splits them into complete frames.
+//
+// For example:
+//
+//	var frameCodec = codec.LengthField(4, binary.BigEndian, 1024*1024)
+//
+//	type Conn struct {
+//	    goev.IOHandle
+//	    dec *codec.Decoder // codec.NewDecoder(frameCodec)
+//	}
+//
+//	func (c *Conn) OnRead() bool {
+//	    buf, n, _ := c.Read()
+//	    if n == 0 {
+//	        return false
+//	    }
+//	    return n < 0 || c.dec.Decode(buf, c.OnMessage) == nil
+//	}
+//	func (c *Conn) OnMessage(msg []byte) bool {
+//	    codec.WriteFrame(c, frameCodec, msg) // echo
+//	    return true
+//	}
+package codec
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/shaovie/goev"
+)
+
+var (
+	// ErrFrameTooLarge means the frame exceeds the max frame size
+	ErrFrameTooLarge = errors.New("codec: frame too large")
+
+	// ErrInvalidFrame means the frame doesn't match the codec
+	ErrInvalidFrame = errors.New("codec: invalid frame")
+)
+
+// Codec splits the data into frames and encodes messages into frames
+type Codec interface {
+	// Split returns the first complete frame in data (without the header/delimiter) and the number
+	// of bytes it takes. advance = 0 means more data is needed.
+	Split(data []byte) (advance int, frame []byte, err error)
+
+	// Encode appends the frame of msg to dst and returns the extended buffer
+	Encode(dst, msg []byte) ([]byte, error)
+}
+
+// Decoder is a per-connection accumulating decoder, it's not safe for concurrent use
+type Decoder struct {
+	c   Codec
+	buf []byte // incomplete frame
+}
+
+// NewDecoder return an instance
+func NewDecoder(c Codec) *Decoder {
+	if c == nil {
+		panic("codec: NewDecoder param is illegal")
+	}
+	return &Decoder{c: c}
+}
+
+// Decode accumulates data and calls onMessage for every complete frame.
+// msg is only valid during the call (it may reference data directly, without copying).
+//
+// Decoding stops when onMessage returns false, and the rest data is kept for the next call.
+// The buffered data is discarded on error, the connection should be closed generally.
+func (d *Decoder) Decode(data []byte, onMessage func(msg []byte) bool) error {
+	if len(d.buf) > 0 {
+		d.buf = append(d.buf, data...)
+		data = d.buf
+	}
+	for len(data) > 0 {
+		advance, frame, err := d.c.Split(data)
+		if err != nil {
+			d.Reset()
+			return err
+		}
+		if advance == 0 {
+			break
+		}
+		data = data[advance:]
+		if onMessage(frame) == false {
+			break
+		}
+	}
+	// Keep the incomplete frame (data may be a part of d.buf, copy is memmove)
+	d.buf = append(d.buf[:0], data...)
+	if len(d.buf) == 0 && cap(d.buf) > 64*1024 {
+		d.buf = nil // Don't hold the large buffer
+	}
+	return nil
+}
+
+// Buffered returns the size of the incomplete frame data
+func (d *Decoder) Buffered() int {
+	return len(d.buf)
+}
+
+// Reset discards the buffered data
+func (d *Decoder) Reset() {
+	d.buf = nil
+}
+
+// WriteFrame encodes msg and writes it by eh.Write (within the evpoll coroutine)
+func WriteFrame(eh goev.EvHandler, c Codec, msg []byte) error {
+	bf, err := encode(c, msg)
+	if err != nil {
+		return err
+	}
+	_, err = eh.Write(bf)
+	goev.BFree(bf) // Write copies the unsent data
+	if err == syscall.EAGAIN {
+		err = nil // Queued, sent by AsyncOrderedFlush
+	}
+	return err
+}
+
+// AsyncWriteFrame encodes msg and writes it by eh.AsyncWrite
+//
+// It is safe for concurrent use by multiple goroutines
+func AsyncWriteFrame(eh goev.EvHandler, c Codec, msg []byte) error {
+	bf, err := encode(c, msg)
+	if err != nil {
+		return err
+	}
+	eh.AsyncWrite(eh, bf)
+	goev.BFree(bf) // AsyncWrite copies the data
+	return nil
+}
+
+func encode(c Codec, msg []byte) ([]byte, error) {
+	bf, err := c.Encode(goev.BMalloc(len(msg) + 8)[:0], msg)
+	if err != nil {
+		goev.BFree(bf)
+		return nil, err
+	}
+	return bf, nil
+}
@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shaovie/goev"
+)
+
+type frameConn struct {
+	goev.IOHandle
+
+	r      *goev.Reactor
+	c      Codec
+	msgs   [][]byte
+	result chan error
+	queued chan int
+}
+
+func (f *frameConn) OnOpen() bool {
+	if err := f.r.AddEvHandler(f, f.Fd(), goev.EvIn); err != nil {
+		return false
+	}
+	for _, msg := range f.msgs {
+		if err := WriteFrame(f, f.c, msg); err != nil {
+			f.result <- err
+			return true
+		}
+	}
+	f.queued <- f.AsyncWaitWriteQLen()
+	f.result <- WriteFrame(f, f.c, make([]byte, 1024*1024+1)) // Too large
+	return true
+}
+func (f *frameConn) OnRead() bool {
+	_, n, _ := f.Read()
+	return n != 0
+}
+func (f *frameConn) OnWrite() bool {
+	f.AsyncOrderedFlush(f)
+	return true
+}
+func (f *frameConn) OnClose() {
+	f.Destroy(f)
+}
+
+func TestWriteFrame(t *testing.T) {
+	r, err := goev.NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := LengthField(4, binary.BigEndian, 1024*1024)
+	big := bytes.Repeat([]byte("0123456789"), 100*1024) // Larger than the socket buffers
+	msgs := [][]byte{[]byte("hello"), big, big, big, big, big, big, big, big, []byte("end")}
+	fc := &frameConn{r: r, c: c, msgs: msgs, result: make(chan error, 1), queued: make(chan int, 1)}
+	a, err := goev.NewAcceptor(r, "127.0.0.1:0", func() goev.EvHandler { return fc })
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	conn, err := net.Dial("tcp", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = <-fc.result; err != ErrFrameTooLarge {
+		t.Fatalf("WriteFrame returned %v, want ErrFrameTooLarge", err)
+	}
+	if n := <-fc.queued; n == 0 {
+		t.Fatal("not partially written")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	d := NewDecoder(c)
+	var got [][]byte
+	buf := make([]byte, 64*1024)
+	for len(got) < len(msgs) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("read after %d frames: %v", len(got), err)
+		}
+		if err = d.Decode(buf[:n], func(msg []byte) bool {
+			got = append(got, append([]byte(nil), msg...))
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range msgs {
+		if !bytes.Equal(got[i], msgs[i]) {
+			t.Fatalf("frame #%d mismatch, %d bytes", i, len(got[i]))
+		}
+	}
+	if d.Buffered() != 0 {
+		t.Fatal("the too large frame was written")
+	}
+}
@@ -0,0 +1,465 @@
+package goev
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// connReadBuffMax reading is paused if the buffered data not read by Conn.Read exceeds it
+	connReadBuffMax = 1 << 20
+
+	// Conn.Write is blocked if the data waiting to be sent exceeds connWriteHighWatermark,
+	// until it drops to connWriteLowWatermark
+	connWriteHighWatermark = 1 << 20
+	connWriteLowWatermark  = 256 << 10
+
+	// connCloseTimeout the max time (millisecond) to send the pending data after Conn.Close
+	connCloseTimeout = 5000
+
+	// connDialTimeout is the default timeout of DialConn
+	connDialTimeout = 30 * time.Second
+)
+
+// Conn is a net.Conn backed by an IOHandle registered in the reactor, so the libraries based on
+// net.Conn (bufio, encoding/gob etc.) can be used on goev connections.
+//
+// Read parks the calling goroutine until OnRead delivers data, Write submits data by AsyncWrite
+// (blocked only if too much data is waiting to be sent), deadlines are implemented with the
+// evPoll timer. Create it by ConnListener.Accept or DialConn.
+//
+// NOTE: Read/Write block the calling goroutine, don't call them within the evpoll coroutine
+type Conn struct {
+	IOHandle
+
+	reactor *Reactor
+	l       *ConnListener
+	openC   chan error // DialConn result
+
+	laddr net.Addr
+	raddr net.Addr
+
+	mtx          sync.Mutex
+	cond         *sync.Cond
+	in           []byte // received, not read yet
+	rerr         error  // io.EOF or the reading error
+	closed       bool   // OnClose has been called
+	userClosed   bool   // Close has been called
+	readPaused   bool
+	writeBlocked bool
+	rd           *connDeadline
+	wd           *connDeadline
+}
+
+func newConn(r *Reactor) *Conn {
+	c := &Conn{reactor: r}
+	c.cond = sync.NewCond(&c.mtx)
+	c.rd = &connDeadline{c: c}
+	c.wd = &connDeadline{c: c}
+	return c
+}
+
+// DialConn connects to addr (refer to Connector.Connect for the format) and returns a Conn
+// registered with r. The default timeout is 30 seconds if timeout equal 0
+func DialConn(r *Reactor, addr string, timeout time.Duration, opts ...Option) (*Conn, error) {
+	if timeout <= 0 {
+		timeout = connDialTimeout
+	}
+	connector, err := NewConnector(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(r)
+	c.openC = make(chan error, 1)
+	msec := timeout.Milliseconds()
+	if msec < 1 {
+		msec = 1
+	}
+	if err = connector.Connect(addr, c, msec); err != nil {
+		return nil, err
+	}
+	if err = <-c.openC; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// OnOpen registers the Conn with the reactor
+func (c *Conn) OnOpen() bool {
+	fd := c.Fd()
+	if sa, err := syscall.Getsockname(fd); err == nil {
+		c.laddr = sockaddrToAddr(sa)
+	}
+	if sa, err := syscall.Getpeername(fd); err == nil {
+		c.raddr = sockaddrToAddr(sa)
+	}
+	if ph := c.ProxyHeader(); ph != nil && ph.SrcAddr != nil {
+		c.laddr, c.raddr = sockaddrToAddr(ph.DstAddr), sockaddrToAddr(ph.SrcAddr)
+	}
+	c.SetWriteWatermark(connWriteHighWatermark, connWriteLowWatermark, false)
+	if err := c.reactor.AddEvHandler(c, fd, EvIn); err != nil {
+		if c.openC != nil {
+			c.openC <- err
+		}
+		return false
+	}
+	if c.openC != nil {
+		c.openC <- nil
+	} else if c.l != nil {
+		c.l.push(c)
+	}
+	return true
+}
+
+// OnConnectFail notifies DialConn
+func (c *Conn) OnConnectFail(err error) {
+	if c.openC != nil {
+		c.openC <- err
+	}
+}
+
+// OnRead buffers the data for Conn.Read
+func (c *Conn) OnRead() bool {
+	buf, n, err := c.IOHandle.Read()
+	if n == 0 || (n < 0 && err != syscall.EAGAIN) {
+		if n == 0 {
+			err = io.EOF
+		}
+		c.mtx.Lock()
+		c.rerr = err
+		c.cond.Broadcast()
+		c.mtx.Unlock()
+		if n == 0 { // Deliver the data written before, we can't write after EOF anyway
+			c.CloseAfterFlush(connCloseTimeout)
+			return true
+		}
+		return false
+	}
+	if n < 0 {
+		return true
+	}
+	c.mtx.Lock()
+	c.in = append(c.in, buf...)
+	if len(c.in) >= connReadBuffMax && !c.readPaused {
+		c.readPaused = c.ep.disableRead(c.Fd()) == nil
+	}
+	c.cond.Broadcast()
+	c.mtx.Unlock()
+	return true
+}
+
+// OnWrite flushes the data waiting to be sent
+func (c *Conn) OnWrite() bool {
+	c.AsyncOrderedFlush(c)
+	return true
+}
+
+// OnHighWatermark blocks Conn.Write
+func (c *Conn) OnHighWatermark(size int) {
+	c.mtx.Lock()
+	c.writeBlocked = true
+	c.mtx.Unlock()
+}
+
+// OnLowWatermark unblocks Conn.Write
+func (c *Conn) OnLowWatermark(size int) {
+	c.mtx.Lock()
+	c.writeBlocked = false
+	c.cond.Broadcast()
+	c.mtx.Unlock()
+}
+
+// OnClose releases the resources and wakes up all the blocked Read/Write
+func (c *Conn) OnClose() {
+	c.rd.stop()
+	c.wd.stop()
+	c.Destroy(c)
+	c.mtx.Lock()
+	c.closed = true
+	if c.rerr == nil {
+		c.rerr = io.EOF
+	}
+	c.cond.Broadcast()
+	c.mtx.Unlock()
+}
+
+// Read reads data from the connection, refer to net.Conn
+//
+// It is safe for concurrent use by multiple goroutines
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.mtx.Lock()
+	for len(c.in) == 0 {
+		if c.userClosed {
+			c.mtx.Unlock()
+			return 0, net.ErrClosed
+		}
+		if c.rerr != nil {
+			err = c.rerr
+			c.mtx.Unlock()
+			return 0, err
+		}
+		if c.rd.expired {
+			c.mtx.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		if len(b) == 0 {
+			c.mtx.Unlock()
+			return 0, nil
+		}
+		c.cond.Wait()
+	}
+	n = copy(b, c.in)
+	c.in = c.in[n:]
+	if len(c.in) == 0 {
+		c.in = nil
+	}
+	resume := c.readPaused && len(c.in) < connReadBuffMax/2
+	if resume {
+		c.readPaused = false
+	}
+	c.mtx.Unlock()
+
+	if resume {
+		c.Post(func() {
+			if c.Fd() > 0 && !c.readStopped() {
+				c.ep.enableRead(c.Fd())
+			}
+		})
+	}
+	return n, nil
+}
+
+// Write writes data to the connection, refer to net.Conn. The data is copied and sent by
+// AsyncWrite, it returns as soon as the data is submitted, unless too much data is waiting to be sent.
+//
+// It is safe for concurrent use by multiple goroutines
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.mtx.Lock()
+	for c.writeBlocked && !c.closed && !c.userClosed && !c.wd.expired {
+		c.cond.Wait()
+	}
+	if c.closed || c.userClosed {
+		c.mtx.Unlock()
+		return 0, net.ErrClosed
+	}
+	if c.wd.expired {
+		c.mtx.Unlock()
+		return 0, os.ErrDeadlineExceeded
+	}
+	c.mtx.Unlock()
+	if len(b) > 0 {
+		c.AsyncWrite(c, b)
+	}
+	return len(b), nil
+}
+
+// Close closes the connection after the data waiting to be sent has been sent (at most 5 seconds),
+// the blocked Read/Write return net.ErrClosed immediately.
+//
+// It is safe for concurrent use by multiple goroutines
+func (c *Conn) Close() error {
+	c.mtx.Lock()
+	if c.userClosed {
+		c.mtx.Unlock()
+		return net.ErrClosed
+	}
+	c.userClosed = true
+	c.cond.Broadcast()
+	c.mtx.Unlock()
+
+	err := c.Post(func() {
+		c.CloseAfterFlush(connCloseTimeout)
+	})
+	if err == net.ErrClosed { // Closed by the peer or the reactor, nothing to flush
+		return nil
+	}
+	return err
+}
+
+// LocalAddr returns the local network address
+func (c *Conn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// RemoteAddr returns the remote network address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// SetDeadline sets the read and write deadlines, refer to net.Conn
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.rd.set(t); err != nil {
+		return err
+	}
+	return c.wd.set(t)
+}
+
+// SetReadDeadline sets the deadline for Read, refer to net.Conn
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.rd.set(t)
+}
+
+// SetWriteDeadline sets the deadline for Write, refer to net.Conn
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.wd.set(t)
+}
+
+// connDeadline is the read/write deadline of Conn, the timer is scheduled in the evPoll of Conn
+type connDeadline struct {
+	c       *Conn
+	seq     uint64  // guarded by c.mtx
+	expired bool    // guarded by c.mtx
+	timer   TimerID // only used within the evpoll coroutine
+}
+
+func (d *connDeadline) set(t time.Time) error {
+	c := d.c
+	c.mtx.Lock()
+	if c.closed || c.userClosed {
+		c.mtx.Unlock()
+		return net.ErrClosed
+	}
+	d.seq++
+	seq := d.seq
+	d.expired = !t.IsZero() && !t.After(time.Now())
+	if d.expired {
+		c.cond.Broadcast()
+	}
+	c.mtx.Unlock()
+
+	return c.Post(func() {
+		d.stop()
+		if t.IsZero() || c.Fd() < 1 {
+			return
+		}
+		c.mtx.Lock()
+		expired, cur := d.expired, d.seq
+		c.mtx.Unlock()
+		if expired || cur != seq { // A newer deadline has been set
+			return
+		}
+		delay := time.Until(t).Milliseconds()
+		if delay < 1 {
+			delay = 1
+		}
+		d.timer, _ = c.ep.scheduleTimer(delay, 0, func(int64) bool {
+			d.timer = 0
+			c.mtx.Lock()
+			if d.seq == seq { // The deadline is exceeded
+				d.expired = true
+				c.cond.Broadcast()
+			}
+			c.mtx.Unlock()
+			return false
+		})
+	})
+}
+
+// stop cancels the timer, within the evpoll coroutine
+func (d *connDeadline) stop() {
+	if d.timer != 0 {
+		d.c.ep.cancelTimer(d.timer)
+		d.timer = 0
+	}
+}
+
+// ConnListener is a net.Listener based on Acceptor, Accept returns *Conn
+type ConnListener struct {
+	acceptor *Acceptor
+	acceptC  chan *Conn
+	closeC   chan struct{}
+	once     sync.Once
+}
+
+// NewConnListener listens on addr (refer to NewAcceptor for the format and options),
+// the accepted Conns are registered with r
+func NewConnListener(r *Reactor, addr string, opts ...Option) (*ConnListener, error) {
+	l := &ConnListener{
+		acceptC: make(chan *Conn, 128),
+		closeC:  make(chan struct{}),
+	}
+	a, err := NewAcceptor(r, addr, func() EvHandler {
+		c := newConn(r)
+		c.l = l
+		return c
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	l.acceptor = a
+	return l, nil
+}
+
+// push is called in the acceptor goroutine after the Conn is registered
+func (l *ConnListener) push(c *Conn) {
+	select {
+	case <-l.closeC:
+	case l.acceptC <- c:
+		return
+	default: // Too many connections not accepted
+	}
+	c.Post(c.closeInPoll)
+}
+
+// Accept waits for and returns the next connection
+func (l *ConnListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.acceptC:
+		return c, nil
+	case <-l.closeC:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops listening, the Conns not accepted are closed
+func (l *ConnListener) Close() error {
+	err := net.ErrClosed
+	l.once.Do(func() {
+		close(l.closeC)
+		err = l.acceptor.Close()
+		for {
+			select {
+			case c := <-l.acceptC:
+				c.Close()
+			default:
+				return
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the listener's network address
+func (l *ConnListener) Addr() net.Addr {
+	return l.acceptor.Addr()
+}
+
+// sockaddrToAddr converts sa to *net.TCPAddr or *net.UnixAddr, nil if it is not supported
+func sockaddrToAddr(sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port}
+	case *syscall.SockaddrInet6:
+		return &net.TCPAddr{IP: append(net.IP(nil), sa.Addr[:]...), Port: sa.Port,
+			Zone: zoneName(sa.ZoneId)}
+	case *syscall.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	}
+	return nil
+}
+
+// zoneName returns the interface name of the IPv6 zone, or the index if it's not found
+func zoneName(id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(id)); err == nil {
+		return ifi.Name
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
@@ -0,0 +1,100 @@
+package goev
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConn(t *testing.T) {
+	r, err := NewReactor(EvPollNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+
+	l, err := NewConnListener(r, "127.0.0.1:8097")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		l.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r.Shutdown(ctx)
+		cancel()
+	}()
+	go func() { // Echo server
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	c, err := DialConn(r, "127.0.0.1:8097", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RemoteAddr().String() != "127.0.0.1:8097" || l.Addr().String() != "127.0.0.1:8097" {
+		t.Fatalf("addr %s %s", c.RemoteAddr(), l.Addr())
+	}
+
+	// Reuse encoding/gob over Conn
+	type msg struct {
+		ID   int
+		Body string
+	}
+	enc, dec := gob.NewEncoder(c), gob.NewDecoder(c)
+	for i := 0; i < 100; i++ {
+		if err = enc.Encode(msg{ID: i, Body: "hello"}); err != nil {
+			t.Fatal(err)
+		}
+		var m msg
+		if err = dec.Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.ID != i || m.Body != "hello" {
+			t.Fatalf("gob msg mismatch %v", m)
+		}
+	}
+
+	// Read deadline
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	buf := make([]byte, 1024)
+	begin := time.Now()
+	if _, err = c.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read err %v, want deadline exceeded", err)
+	}
+	if d := time.Since(begin); d < 40*time.Millisecond || d > time.Second {
+		t.Fatalf("read deadline fired after %s", d)
+	}
+	c.SetReadDeadline(time.Time{})
+
+	// Large payload, exceeds the watermarks and connReadBuffMax
+	want := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	go func() {
+		c.Write(want)
+	}()
+	got := make([]byte, len(want))
+	if _, err = io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("large payload mismatch")
+	}
+
+	c.Close()
+	if _, err = c.Read(buf); err == nil {
+		t.Fatal("read after close")
+	}
+}
@@ -2,8 +2,6 @@ package goev
 
 import (
 	"errors"
-	"net"
-	"strconv"
 	"strings"
 	"syscall"
 )
@@ -24,6 +22,7 @@ var (
 // Connect success or failure will trigger specified methods for notification
 type Connector struct {
 	sockRcvBufSize int // ignore equal 0
+	sockSndBufSize int // ignore equal 0
 
 	reactor *Reactor
 }
@@ -33,6 +32,7 @@ func NewConnector(r *Reactor, opts ...Option) (*Connector, error) {
 	evOptions := setOptions(opts...)
 	c := &Connector{
 		sockRcvBufSize: evOptions.sockRcvBufSize,
+		sockSndBufSize: evOptions.sockSndBufSize,
 		reactor:        r,
 	}
 	return c, nil
@@ -41,7 +41,8 @@ func NewConnector(r *Reactor, opts ...Option) (*Connector, error) {
 // Connect asynchronously to the specified address and there may also be an immediate result.
 // Please check the return value
 //
-// The addr format 192.168.0.1:8080 or unix:/tmp/xxxx.sock
+// The addr format 192.168.0.1:8080 or [::1]:8080 or [fe80::1%eth0]:8080 or unix:/tmp/xxxx.sock
+// or unix:@name (Linux abstract socket) or unixpacket:/tmp/xxxx.sock (SOCK_SEQPACKET)
 // The domain name format, such as qq.com:8080, is not supported.
 // You need to manually extract the IP address using gethostbyname.
 //
@@ -54,21 +55,65 @@ func (c *Connector) Connect(addr string, eh EvHandler, timeout int64) error {
 	if p < 0 || p >= (len(addr)-1) {
 		return errors.New("Connector:Connect param:addr invalid")
 	}
-	if len(addr) > 5 {
-		s := addr[0:5]
-		if s == "unix:" {
-			return c.udsConnect(addr[5:], eh, timeout)
-		}
+	if strings.HasPrefix(addr, "unix:") {
+		return c.udsConnect(addr[5:], syscall.SOCK_STREAM, eh, timeout)
+	} else if strings.HasPrefix(addr, "unixpacket:") {
+		return c.udsConnect(addr[11:], syscall.SOCK_SEQPACKET, eh, timeout)
 	}
 	return c.tcpConnect(addr, eh, timeout)
 }
 
-// The addr format 192.168.0.1:8080
+// ConnectData connects to the TCP address with TCP Fast Open, `data' (e.g. the first request) is
+// sent in the SYN if the client has the TFO cookie of the server, otherwise the cookie is requested
+// and the data is sent after the handshake (the next connection will be fast). The data has been
+// sent when eh.OnOpen is called, and it must not exceed 16KB.
+//
+// It falls back to the normal connect if TFO is disabled by `sysctl net.ipv4.tcp_fastopen'.
+// The other params are the same as Connect, but the timeout must be > 0
+func (c *Connector) ConnectData(addr string, eh EvHandler, timeout int64, data []byte) error {
+	if timeout < 1 {
+		return errors.New("Connector:ConnectData param:timeout < 1")
+	}
+	if len(data) == 0 || len(data) > maxFastOpenDataSize {
+		return errors.New("Connector:ConnectData param:data invalid")
+	}
+	sa, domain, err := resolveTCPAddr(addr)
+	if err != nil {
+		return err
+	}
+	fd, err := c.tcpSocket(domain)
+	if err != nil {
+		return err
+	}
+	n, err := sendtoFastOpen(fd, data, sa)
+	if err == syscall.EOPNOTSUPP { // The client side of TFO is disabled
+		return c.connect(fd, sa, eh, timeout, data)
+	} else if err != nil && err != syscall.EINPROGRESS {
+		syscall.Close(fd)
+		return errors.New("syscall sendto MSG_FASTOPEN: " + err.Error())
+	}
+	// In progress, the unsent part is written after the handshake
+	return c.inProgress(fd, eh, timeout, data[n:])
+}
+
+// The addr format 192.168.0.1:8080 or [::1]:8080
 func (c *Connector) tcpConnect(addr string, eh EvHandler, timeout int64) error {
-	fd, err := syscall.Socket(syscall.AF_INET,
+	sa, domain, err := resolveTCPAddr(addr)
+	if err != nil {
+		return err
+	}
+	fd, err := c.tcpSocket(domain)
+	if err != nil {
+		return err
+	}
+	return c.connect(fd, sa, eh, timeout, nil)
+}
+
+func (c *Connector) tcpSocket(domain int) (int, error) {
+	fd, err := syscall.Socket(domain,
 		syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
 	if err != nil {
-		return errors.New("Socket in connector.open: " + err.Error())
+		return -1, errors.New("Socket in connector.open: " + err.Error())
 	}
 
 	if c.sockRcvBufSize > 0 {
@@ -80,47 +125,35 @@ func (c *Connector) tcpConnect(addr string, eh EvHandler, timeout int64) error {
 		err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, c.sockRcvBufSize)
 		if err != nil {
 			syscall.Close(fd)
-			return errors.New("Set SO_RCVBUF: " + err.Error())
+			return -1, errors.New("Set SO_RCVBUF: " + err.Error())
 		}
 	}
-
-	ip := "0.0.0.0"
-	var port int64
-	ipp := strings.Split(addr, ":")
-	if len(ipp) != 2 {
-		syscall.Close(fd)
-		return errors.New("address is invalid! 192.168.1.1:80")
-	}
-	if len(ipp[0]) > 0 {
-		ip = ipp[0]
-	}
-	ip4 := net.ParseIP(ip)
-	if ip4 == nil {
-		syscall.Close(fd)
-		return errors.New("address is invalid! 192.168.1.1:80")
-	}
-	port, _ = strconv.ParseInt(ipp[1], 10, 64)
-	if port < 1 || port > 65535 {
-		syscall.Close(fd)
-		return errors.New("port must in (0, 65536)")
+	if c.sockSndBufSize > 0 {
+		// must < `sysctl -a | grep net.core.wmem_max`
+		err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, c.sockSndBufSize)
+		if err != nil {
+			syscall.Close(fd)
+			return -1, errors.New("Set SO_SNDBUF: " + err.Error())
+		}
 	}
-	sa := syscall.SockaddrInet4{Port: int(port)}
-	copy(sa.Addr[:], ip4.To4())
-	return c.connect(fd, &sa, eh, timeout)
+	return fd, nil
 }
 
-func (c *Connector) udsConnect(addr string, eh EvHandler, timeout int64) error {
+// The addr format /tmp/xxx.sock or @name
+func (c *Connector) udsConnect(addr string, typ int, eh EvHandler, timeout int64) error {
 	fd, err := syscall.Socket(syscall.AF_UNIX,
-		syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+		typ|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return errors.New("Socket in connector.open: " + err.Error())
 	}
 	// SO_RCVBUF is invalid for unix sock
 	rsu := syscall.SockaddrUnix{Name: addr}
-	return c.connect(fd, &rsu, eh, timeout)
+	return c.connect(fd, &rsu, eh, timeout, nil)
 }
 
-func (c *Connector) connect(fd int, sa syscall.Sockaddr, eh EvHandler, timeout int64) (err error) {
+// connect writes `pending' (if any) before eh.OnOpen when the connection is established
+func (c *Connector) connect(fd int, sa syscall.Sockaddr, eh EvHandler, timeout int64,
+	pending []byte) (err error) {
 	for {
 		err = syscall.Connect(fd, sa)
 		if err == syscall.EINTR {
@@ -133,15 +166,15 @@ func (c *Connector) connect(fd int, sa syscall.Sockaddr, eh EvHandler, timeout i
 			syscall.Close(fd)
 			return ErrConnectInprogress
 		}
-		ipc := &inProgressConnect{eh: eh}
-		if err = c.reactor.AddEvHandler(ipc, fd, EvConnect); err != nil {
+		return c.inProgress(fd, eh, timeout, pending)
+	} else if err == nil { // success
+		n, err := writeAll(fd, pending)
+		if err == syscall.EAGAIN { // The rest is written on EvOut
+			return c.inProgress(fd, eh, timeout, pending[n:])
+		} else if err != nil {
 			syscall.Close(fd)
-			return errors.New("InPorgress AddEvHandler in connector.Connect: " + err.Error())
+			return errors.New("Write pending data in connector.Connect: " + err.Error())
 		}
-		// AddEvHandler 和 ScheduleTimer 不保证原子性, 有可能 ScheduleTimer的时候 ipc已经调用了OnClose()
-		ipc.ScheduleTimer(ipc, timeout, 0) // don't need to cancel it when conn error
-		return nil
-	} else if err == nil { // success
 		eh.setFd(fd)
 		if eh.OnOpen() == false {
 			eh.OnClose()
@@ -152,17 +185,33 @@ func (c *Connector) connect(fd int, sa syscall.Sockaddr, eh EvHandler, timeout i
 	return errors.New("syscall connect: " + err.Error())
 }
 
+func (c *Connector) inProgress(fd int, eh EvHandler, timeout int64, pending []byte) error {
+	ipc := &inProgressConnect{eh: eh, pending: pending}
+	if err := c.reactor.AddEvHandler(ipc, fd, EvConnect); err != nil {
+		syscall.Close(fd)
+		return errors.New("InPorgress AddEvHandler in connector.Connect: " + err.Error())
+	}
+	// AddEvHandler 和 ScheduleTimer 不保证原子性, 有可能 ScheduleTimer的时候 ipc已经调用了OnClose()
+	ipc.ScheduleTimer(ipc, timeout, 0) // don't need to cancel it when conn error
+	return nil
+}
+
 // nonblocking inprogress connection
 type inProgressConnect struct {
 	IOHandle
 
 	ok        bool
 	ioHandled bool
+	connected bool // Established, writing the pending data
 	eh        EvHandler
+	pending   []byte // The data of ConnectData not sent in the SYN
 }
 
 // Called by reactor when asynchronous connections fail.
 func (p *inProgressConnect) OnRead() bool {
+	if p.connected { // Reported with EPOLLOUT in the same event
+		return true
+	}
 	p.eh.OnConnectFail(ErrConnectFail)
 	p.ioHandled = true
 	p.CancelTimer(p)
@@ -171,12 +220,29 @@ func (p *inProgressConnect) OnRead() bool {
 
 // Called by reactor when asynchronous connections succeed.
 func (p *inProgressConnect) OnWrite() bool {
-	// From here on, the `fd` resources will be managed by eh.
-	fd := p.Fd()
-	p.setFd(-1)
+	if len(p.pending) > 0 {
+		if !p.connected {
+			// Don't take the response or EOF as a connection failure in OnRead
+			p.connected = true
+			p.getEvPoll().remove(p.Fd(), EvIn)
+		}
+		n, err := writeAll(p.Fd(), p.pending)
+		p.pending = p.pending[n:]
+		if err == syscall.EAGAIN { // Wait for EvOut within the connect timeout
+			return true
+		} else if err != nil {
+			p.ioHandled = true
+			p.CancelTimer(p)
+			p.eh.OnConnectFail(ErrConnectFail)
+			return false // goto p.OnClose(), the fd is closed
+		}
+	}
 	p.ioHandled = true
 	p.CancelTimer(p)
 
+	// From here on, the `fd` resources will be managed by eh.
+	fd := p.Fd()
+	p.setFd(-1)
 	p.ok = true
 	p.eh.setFd(fd)
 	return false
@@ -189,6 +255,7 @@ func (p *inProgressConnect) OnTimeout(now int64) bool {
 	}
 
 	// i/o event not catched
+	p.ioHandled = true
 	p.getEvPoll().remove(p.Fd(), EvAll)
 	p.eh.OnConnectFail(ErrConnectTimeout)
 	p.OnClose()
@@ -197,8 +264,14 @@ func (p *inProgressConnect) OnTimeout(now int64) bool {
 
 // OnClose maybe trigger EPOLLHUP | EPOLLERR
 func (p *inProgressConnect) OnClose() {
+	p.CancelTimer(p)
 	p.Destroy(p)
 
+	if !p.ioHandled { // EPOLLHUP | EPOLLERR
+		p.ioHandled = true
+		p.eh.OnConnectFail(ErrConnectFail)
+	}
+
 	if p.ok == true && p.eh.OnOpen() == false {
 		p.eh.OnClose()
 	}
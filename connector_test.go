@@ -1,11 +1,17 @@
 package goev
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"runtime"
 	"strconv"
 	"sync"
+	"syscall"
 	"testing"
+	"time"
 )
 
 type Scanner struct {
@@ -47,3 +53,95 @@ func TestConnector(t *testing.T) {
 
 	wg.Wait()
 }
+
+type refusedConn struct {
+	IOHandle
+
+	fails chan error
+}
+
+func (c *refusedConn) OnOpen() bool {
+	c.fails <- nil
+	return false
+}
+func (c *refusedConn) OnConnectFail(err error) {
+	c.fails <- err
+}
+func (c *refusedConn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestConnectorRefused(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	fails := make(chan error, 4)
+	c, _ := NewConnector(r)
+	// Nothing listens on it, EPOLLHUP|EPOLLERR closes the connection before the timer expires
+	if err = c.Connect("127.0.0.1:1", &refusedConn{fails: fails}, 50); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond) // The timer must have been canceled
+	if len(fails) != 1 {
+		t.Fatalf("%d notifications", len(fails))
+	}
+	if err = <-fails; err != ErrConnectFail {
+		t.Fatalf("OnConnectFail(%v)", err)
+	}
+}
+
+func TestConnectorConnectDataBufFull(t *testing.T) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		return c.Control(func(fd uintptr) { // Small window, the client's send buffer gets full
+			syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, 4096)
+		})
+	}}
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	c, _ := NewConnector(r, SockSndBufSize(4096))
+	data := bytes.Repeat([]byte("x"), maxFastOpenDataSize)
+	echo := make(chan string, 1)
+	if err = c.ConnectData(ln.Addr().String(), &fastOpenConn{r: r, echo: echo}, 3000, data); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case s := <-echo:
+		t.Fatalf("connect failed: %s", s)
+	default:
+	}
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, len(data))
+	if _, err = io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Fatalf("read data: %v", err)
+	}
+	conn.Write([]byte("ok")) // Read by the handler after OnOpen
+	select {
+	case s := <-echo:
+		if s != "ok" {
+			t.Fatalf("echo %q", s)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("ConnectData timeout")
+	}
+}
@@ -0,0 +1,281 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// DgramHandler is the interface of datagram event handling objects
+type DgramHandler interface {
+	EvHandler
+
+	setDgramHandler(dh DgramHandler, recvBatch int)
+
+	// OnDatagram is called for every datagram received (within the evpoll coroutine).
+	// buf uses evPollReadBuff and is only valid during the call. The truncated datagram
+	// (larger than EvPollReadBuffSize/DgramRecvBatch) is discarded.
+	//
+	// Call OnClose() when return false
+	OnDatagram(buf []byte, from syscall.Sockaddr) bool
+}
+
+// mmsghdr refer to `man 2 recvmmsg`
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+// DgramHandle is the base class of datagram event handling objects (e.g. UDP)
+//
+// The datagrams are received by recvmmsg in batches, and are sent by sendto/sendmmsg
+type DgramHandle struct {
+	IOHandle
+
+	dh DgramHandler
+
+	recvBatch int
+	recvHdrs  []mmsghdr
+	recvIovs  []syscall.Iovec
+	recvNames []syscall.RawSockaddrAny
+}
+
+// NewUDPListener create an UDP socket bound to addr, and register handler with the reactor
+//
+// The addr format 192.168.0.1:8080 or :8080 or [::]:8080
+// Options ReuseAddr, ReusePort, IPv6Only, SockRcvBufSize and DgramRecvBatch are valid here
+func NewUDPListener(r *Reactor, addr string, handler DgramHandler, opts ...Option) error {
+	if handler == nil {
+		return errors.New("NewUDPListener: handler is nil")
+	}
+	evOptions := setOptions(opts...)
+	if evOptions.dgramRecvBatch > len(r.evPolls[0].evPollReadBuff) {
+		return errors.New("NewUDPListener: DgramRecvBatch is larger than EvPollReadBuffSize")
+	}
+	sa, domain, err := resolveListenAddr(addr)
+	if err != nil {
+		return err
+	}
+	fd, err := syscall.Socket(domain, syscall.SOCK_DGRAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return errors.New("Socket in NewUDPListener: " + err.Error())
+	}
+	if evOptions.reuseAddr == true {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+			syscall.Close(fd)
+			return errors.New("Set SO_REUSEADDR in NewUDPListener: " + err.Error())
+		}
+	}
+	if evOptions.reusePort == true {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			syscall.Close(fd)
+			return errors.New("Set SO_REUSEPORT in NewUDPListener: " + err.Error())
+		}
+	}
+	if domain == syscall.AF_INET6 {
+		v := 0
+		if evOptions.ipv6Only == true {
+			v = 1
+		}
+		if err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, v); err != nil {
+			syscall.Close(fd)
+			return errors.New("Set IPV6_V6ONLY in NewUDPListener: " + err.Error())
+		}
+	}
+	if evOptions.sockRcvBufSize > 0 {
+		err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, evOptions.sockRcvBufSize)
+		if err != nil {
+			syscall.Close(fd)
+			return errors.New("Set SO_RCVBUF: " + err.Error())
+		}
+	}
+	if err = syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return errors.New("syscall bind: " + err.Error())
+	}
+
+	handler.setDgramHandler(handler, evOptions.dgramRecvBatch)
+	handler.setFd(fd)
+	if err = r.AddEvHandler(handler, fd, EvIn); err != nil {
+		handler.setFd(-1)
+		syscall.Close(fd)
+		return errors.New("AddEvHandler in NewUDPListener: " + err.Error())
+	}
+	return nil
+}
+
+func (d *DgramHandle) setDgramHandler(dh DgramHandler, recvBatch int) {
+	d.dh = dh
+	if recvBatch < 1 {
+		recvBatch = 1
+	}
+	d.recvBatch = recvBatch
+	d.recvHdrs = make([]mmsghdr, recvBatch)
+	d.recvIovs = make([]syscall.Iovec, recvBatch)
+	d.recvNames = make([]syscall.RawSockaddrAny, recvBatch)
+}
+
+// OnRead receives datagrams in batches and calls OnDatagram for each one
+func (d *DgramHandle) OnRead() bool {
+	fd := d.Fd()
+	if fd < 1 {
+		return false
+	}
+	buf := d.ep.evPollReadBuff
+	slotSize := len(buf) / d.recvBatch
+	for loop := 0; loop < 8; loop++ { // Don't process too many at once
+		for i := 0; i < d.recvBatch; i++ {
+			d.recvIovs[i].Base = &buf[i*slotSize]
+			d.recvIovs[i].SetLen(slotSize)
+			h := &d.recvHdrs[i].hdr
+			h.Name = (*byte)(unsafe.Pointer(&d.recvNames[i]))
+			h.Namelen = syscall.SizeofSockaddrAny
+			h.Iov = &d.recvIovs[i]
+			h.Iovlen = 1
+			h.Flags = 0
+		}
+		n, err := recvmmsg(fd, d.recvHdrs)
+		if err != nil { // EAGAIN or the ICMP error of previous sending, ignore it
+			break
+		}
+		for i := 0; i < n; i++ {
+			mh := &d.recvHdrs[i]
+			if mh.hdr.Flags&syscall.MSG_TRUNC != 0 {
+				continue
+			}
+			off := i * slotSize
+			if d.dh.OnDatagram(buf[off:off+int(mh.len)], rawToSockaddr(&d.recvNames[i])) == false {
+				return false
+			}
+		}
+		if n < d.recvBatch {
+			break
+		}
+	}
+	return true
+}
+
+// WriteTo sends a datagram to the specified address synchronously (sendto)
+//
+// Datagrams are not queued, syscall.EAGAIN is returned if the socket send buffer is full
+func (d *DgramHandle) WriteTo(buf []byte, to syscall.Sockaddr) error {
+	fd := d.Fd()
+	if fd < 1 {
+		return syscall.EBADF
+	}
+	for {
+		err := syscall.Sendto(fd, buf, 0, to)
+		if err == syscall.EINTR {
+			continue
+		}
+		return err
+	}
+}
+
+// WriteToBatch sends multiple datagrams by one sendmmsg syscall, bufs[i] is sent to tos[i]
+//
+// Return the number of datagrams sent, the rest may be resent later
+func (d *DgramHandle) WriteToBatch(bufs [][]byte, tos []syscall.Sockaddr) (int, error) {
+	fd := d.Fd()
+	if fd < 1 {
+		return 0, syscall.EBADF
+	}
+	if len(bufs) != len(tos) {
+		return 0, errors.New("WriteToBatch: len(bufs) != len(tos)")
+	}
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+	hdrs := make([]mmsghdr, len(bufs))
+	iovs := make([]syscall.Iovec, len(bufs))
+	names := make([]syscall.RawSockaddrAny, len(bufs))
+	for i := range bufs {
+		if len(bufs[i]) > 0 {
+			iovs[i].Base = &bufs[i][0]
+			iovs[i].SetLen(len(bufs[i]))
+		}
+		nameLen, err := sockaddrToRaw(tos[i], &names[i])
+		if err != nil {
+			return 0, err
+		}
+		hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		hdrs[i].hdr.Namelen = nameLen
+		hdrs[i].hdr.Iov = &iovs[i]
+		hdrs[i].hdr.Iovlen = 1
+	}
+	return sendmmsg(fd, hdrs)
+}
+
+// OnClose release the fd by default
+func (d *DgramHandle) OnClose() {
+	d.Destroy(d)
+}
+
+func recvmmsg(fd int, hdrs []mmsghdr) (int, error) {
+	for {
+		n, _, errno := syscall.Syscall6(unix.SYS_RECVMMSG, uintptr(fd),
+			uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), 0, 0, 0)
+		if errno != 0 {
+			if errno == syscall.EINTR {
+				continue
+			}
+			return 0, errno
+		}
+		return int(n), nil
+	}
+}
+
+func sendmmsg(fd int, hdrs []mmsghdr) (int, error) {
+	for {
+		n, _, errno := syscall.Syscall6(unix.SYS_SENDMMSG, uintptr(fd),
+			uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), 0, 0, 0)
+		if errno != 0 {
+			if errno == syscall.EINTR {
+				continue
+			}
+			return 0, errno
+		}
+		return int(n), nil
+	}
+}
+
+func rawToSockaddr(rsa *syscall.RawSockaddrAny) syscall.Sockaddr {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa := &syscall.SockaddrInet4{Addr: pp.Addr}
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		return sa
+	case syscall.AF_INET6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		sa := &syscall.SockaddrInet6{Addr: pp.Addr, ZoneId: pp.Scope_id}
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		sa.Port = int(p[0])<<8 + int(p[1])
+		return sa
+	}
+	return nil
+}
+
+func sockaddrToRaw(sa syscall.Sockaddr, rsa *syscall.RawSockaddrAny) (uint32, error) {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		pp := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		pp.Family = syscall.AF_INET
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		pp.Addr = sa.Addr
+		return syscall.SizeofSockaddrInet4, nil
+	case *syscall.SockaddrInet6:
+		pp := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		pp.Family = syscall.AF_INET6
+		p := (*[2]byte)(unsafe.Pointer(&pp.Port))
+		p[0], p[1] = byte(sa.Port>>8), byte(sa.Port)
+		pp.Scope_id = sa.ZoneId
+		pp.Addr = sa.Addr
+		return syscall.SizeofSockaddrInet6, nil
+	}
+	return 0, syscall.EAFNOSUPPORT
+}
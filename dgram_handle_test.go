@@ -0,0 +1,91 @@
+package goev
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type udpEcho struct {
+	DgramHandle
+}
+
+func (u *udpEcho) OnDatagram(buf []byte, from syscall.Sockaddr) bool {
+	if string(buf) == "batch" {
+		u.WriteToBatch([][]byte{[]byte("b1"), []byte("b2")}, []syscall.Sockaddr{from, from})
+		return true
+	}
+	u.WriteTo(buf, from)
+	return true
+}
+
+func TestUDPListener(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = NewUDPListener(r, "127.0.0.1:8095", &udpEcho{}, DgramRecvBatch(8)); err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	conn, err := net.Dial("udp", "127.0.0.1:8095")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	buf := make([]byte, 64)
+	for _, s := range []string{"hello", "goev"} {
+		conn.Write([]byte(s))
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != s {
+			t.Fatalf("echo %s: %q %v", s, buf[:n], err)
+		}
+	}
+	conn.Write([]byte("batch"))
+	for _, s := range []string{"b1", "b2"} {
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != s {
+			t.Fatalf("batch %s: %q %v", s, buf[:n], err)
+		}
+	}
+}
+
+func TestUDPListenerLargeDatagram(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = NewUDPListener(r, "127.0.0.1:0", &udpEcho{}, DgramRecvBatch(8192+1)); err == nil {
+		t.Fatal("DgramRecvBatch larger than EvPollReadBuffSize accepted")
+	}
+	echo := &udpEcho{}
+	if err = NewUDPListener(r, "127.0.0.1:0", echo); err != nil { // The default batch is 1
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	sa, _ := syscall.Getsockname(echo.Fd())
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1),
+		Port: sa.(*syscall.SockaddrInet4).Port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	msg := make([]byte, 4000)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	conn.Write(msg)
+	buf := make([]byte, 8192)
+	if n, err := conn.Read(buf); err != nil || n != len(msg) {
+		t.Fatalf("echo %d bytes %v", n, err)
+	}
+}
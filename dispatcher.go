@@ -0,0 +1,115 @@
+package goev
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Dispatcher selects the evPoll that the fd will be registered with in Reactor.AddEvHandler.
+// The return value MUST be in [0, r.EvPollNum()), otherwise fd % r.EvPollNum() is used.
+//
+// The selected evPoll is remembered per fd, so that AppendEvent/RemoveEvent are routed consistently.
+// It may be called by multiple goroutines at the same time.
+type Dispatcher func(r *Reactor, fd int) int
+
+// DispatchByFd is the default dispatcher, fd % evPollNum.
+//
+// fd is a self-incrementing and cyclic integer, can be allocated through round-robin distribution.
+func DispatchByFd(r *Reactor, fd int) int {
+	return fd % r.evPollNum
+}
+
+// DispatchRoundRobin returns a dispatcher that distributes fds to evPolls in turn
+func DispatchRoundRobin() Dispatcher {
+	var next atomic.Uint32
+	return func(r *Reactor, fd int) int {
+		return int((next.Add(1) - 1) % uint32(r.evPollNum))
+	}
+}
+
+// DispatchLeastConn selects the evPoll with the fewest registered handlers
+func DispatchLeastConn(r *Reactor, fd int) int {
+	idx := 0
+	min := r.EvPollLoad(0)
+	for i := 1; i < r.evPollNum; i++ {
+		if n := r.EvPollLoad(i); n < min {
+			idx, min = i, n
+		}
+	}
+	return idx
+}
+
+// DispatchRemoteAddrHash selects the evPoll by the hash of the peer IP, so that the connections
+// from the same client are handled in the same evPoll.
+//
+// Fallback to DispatchByFd if the fd is not a TCP socket
+func DispatchRemoteAddrHash(r *Reactor, fd int) int {
+	var ip net.IP
+	sa, _ := syscall.Getpeername(fd)
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		ip = sa.Addr[:]
+	case *syscall.SockaddrInet6:
+		ip = sa.Addr[:]
+	default:
+		return DispatchByFd(r, fd)
+	}
+	h := fnv.New32a()
+	h.Write(ip)
+	return int(h.Sum32() % uint32(r.evPollNum))
+}
+
+// DispatchIncomingCPU selects the evPoll by SO_INCOMING_CPU of the fd (the CPU that handled its
+// packets) % evPollNum, so that the connection is handled near the CPU receiving its packets,
+// it works best with EvPollNum(number of CPUs) and the evPoll threads bound to the CPUs.
+// Refer to ReusePortSteerByCPU for multiple reactors
+//
+// Fallback to DispatchByFd if SO_INCOMING_CPU is not available
+func DispatchIncomingCPU(r *Reactor, fd int) int {
+	cpu, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_INCOMING_CPU)
+	if err != nil || cpu < 0 {
+		return DispatchByFd(r, fd)
+	}
+	return cpu % r.evPollNum
+}
+
+// fdPollMap remember the evPoll index for each fd, same structure as evDataMap
+type fdPollMap struct {
+	arrSize int
+	arr     []atomic.Int32
+
+	sMap   map[int]int
+	mapMtx sync.Mutex
+}
+
+func newFdPollMap(arrSize int) *fdPollMap {
+	return &fdPollMap{
+		arrSize: arrSize,
+		arr:     make([]atomic.Int32, arrSize),
+		sMap:    make(map[int]int, 128),
+	}
+}
+
+func (fm *fdPollMap) store(fd, idx int) {
+	if fd < fm.arrSize {
+		fm.arr[fd].Store(int32(idx))
+		return
+	}
+	fm.mapMtx.Lock()
+	fm.sMap[fd] = idx
+	fm.mapMtx.Unlock()
+}
+
+func (fm *fdPollMap) load(fd int) int {
+	if fd < fm.arrSize {
+		return int(fm.arr[fd].Load())
+	}
+	fm.mapMtx.Lock()
+	defer fm.mapMtx.Unlock()
+	return fm.sMap[fd]
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -15,15 +17,27 @@ type evPoll struct {
 	evPollReadBuff  []byte
 	evPollWriteBuff []byte
 
+	// writev scratch, reused within evPoll
+	iovecs   []syscall.Iovec
+	iovBufs  [][]byte
+	iovAbufs []asyncWriteBuf
+
 	evHandlerMap *evDataMap // Refer to https://zhuanlan.zhihu.com/p/640712548
-	timer        *timer4Heap
+	handlerNum   atomic.Int32
+	timer        evTimer
+	clock        *evClock
+	idleWheel    *idleWheel
 
 	asyncWrite       *asyncWrite
 	pollSyncOpterate *pollSyncOpt
 	pCache           map[int]any
+
+	done             chan struct{} // closed when run returns
+	shutdowning      bool
+	shutdownDeadline int64 // unix millisecond, 0 means waiting until all data is flushed
 }
 
-func (ep *evPoll) open(evFdMaxSize int, timer *timer4Heap,
+func (ep *evPoll) open(evFdMaxSize int, timer evTimer, clock *evClock,
 	evPollReadBuffSize, evPollWriteBuffSize int) error {
 	efd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
 	if err != nil {
@@ -31,9 +45,14 @@ func (ep *evPoll) open(evFdMaxSize int, timer *timer4Heap,
 	}
 	ep.efd = efd
 	ep.timer = timer
+	ep.clock = clock
 	ep.evPollReadBuff = make([]byte, evPollReadBuffSize)
 	ep.evPollWriteBuff = make([]byte, evPollWriteBuffSize)
+	ep.iovecs = make([]syscall.Iovec, 0, ioVecMax)
+	ep.iovBufs = make([][]byte, 0, ioVecMax)
+	ep.iovAbufs = make([]asyncWriteBuf, 0, ioVecMax)
 	ep.pCache = make(map[int]any, 16)
+	ep.done = make(chan struct{})
 	ep.evHandlerMap = newEvDataMap(evFdMaxSize)
 	if ep.asyncWrite, err = newAsyncWrite(ep); err != nil {
 		return err
@@ -50,7 +69,7 @@ func (ep *evPoll) open(evFdMaxSize int, timer *timer4Heap,
 
 // first register
 func (ep *evPoll) add(fd int, events uint32, eh EvHandler) error {
-	eh.setParams(fd, ep)
+	eh.setParams(fd, ep, eh)
 
 	ev := syscall.EpollEvent{Events: events}
 	ed := ep.evHandlerMap.newOne(fd)
@@ -64,12 +83,19 @@ func (ep *evPoll) add(fd int, events uint32, eh EvHandler) error {
 		// ENOSPC cat /proc/sys/fs/epoll/max_user_watches
 		return errors.New("epoll_ctl add: " + err.Error())
 	}
+	ep.handlerNum.Add(1)
 	return nil
 }
 func (ep *evPoll) remove(fd int, events uint32) error {
+	if fd < 1 { // Closed
+		return errors.New("remove: invalid fd")
+	}
 	if events == EvAll {
 		// The event argument is ignored and can be NULL (but see `man 2 epoll_ctl` BUGS)
 		// kernel versions > 2.6.9
+		if ep.evHandlerMap.load(fd) != nil {
+			ep.handlerNum.Add(-1)
+		}
 		ep.evHandlerMap.del(fd)
 		if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_DEL, fd, nil); err != nil {
 			return errors.New("epoll_ctl del: " + err.Error())
@@ -82,6 +108,7 @@ func (ep *evPoll) remove(fd int, events uint32) error {
 	}
 
 	if ed.events&^events == 0 {
+		ep.handlerNum.Add(-1)
 		ep.evHandlerMap.del(fd)
 		if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_DEL, fd, nil); err != nil {
 			return errors.New("epoll_ctl del: " + err.Error())
@@ -117,10 +144,50 @@ func (ep *evPoll) append(fd int, events uint32) error {
 	}
 	return nil
 }
+
+// disableRead removes EvIn without unregistering fd (only for the level-triggered handler).
+// EPOLLET keeps the events non-zero, otherwise remove(fd, EvOut) would unregister fd.
+// EPOLLHUP/EPOLLERR are still reported. All the read pausing (backpressure, CloseAfterFlush,
+// Acceptor.Pause) goes through it
+func (ep *evPoll) disableRead(fd int) error {
+	ed := ep.evHandlerMap.load(fd)
+	if ed == nil {
+		return errors.New("disableRead: not found")
+	}
+	old := ed.events
+	ed.events = (ed.events &^ EvIn) | EPOLLET
+
+	ev := syscall.EpollEvent{Events: ed.events}
+	*(**evData)(unsafe.Pointer(&ev.Fd)) = ed
+	if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_MOD, fd, &ev); err != nil {
+		ed.events = old
+		return errors.New("epoll_ctl mod: " + err.Error())
+	}
+	return nil
+}
+
+// enableRead re-enables EPOLLIN disabled by disableRead
+func (ep *evPoll) enableRead(fd int) error {
+	ed := ep.evHandlerMap.load(fd)
+	if ed == nil {
+		return errors.New("enableRead: not found")
+	}
+	old := ed.events
+	ed.events = (ed.events &^ EPOLLET) | EvIn
+
+	ev := syscall.EpollEvent{Events: ed.events}
+	*(**evData)(unsafe.Pointer(&ev.Fd)) = ed
+	if err := syscall.EpollCtl(ep.efd, syscall.EPOLL_CTL_MOD, fd, &ev); err != nil {
+		ed.events = old
+		return errors.New("epoll_ctl mod: " + err.Error())
+	}
+	return nil
+}
 func (ep *evPoll) run(wg *sync.WaitGroup) error {
 	if wg != nil {
 		defer wg.Done()
 	}
+	defer close(ep.done)
 
 	var nfds, i, msec int
 	var err error
@@ -128,6 +195,7 @@ func (ep *evPoll) run(wg *sync.WaitGroup) error {
 	msec = -1
 	for {
 		nfds, err = syscall.EpollWait(ep.efd, events, msec)
+		ep.clock.update()
 		if nfds > 0 {
 			msec = 0
 			for i = 0; i < nfds; i++ {
@@ -168,18 +236,72 @@ func (ep *evPoll) run(wg *sync.WaitGroup) error {
 		} else if nfds == 0 || (nfds < 0 && err == syscall.EINTR) { // timeout
 			msec = -1
 			runtime.Gosched() // https://zhuanlan.zhihu.com/p/647958433
-			continue
 		} else if err != nil {
 			return errors.New("syscall epoll_wait: " + err.Error())
 		}
+		if ep.shutdowning {
+			if ep.shutdownStep() {
+				return nil
+			}
+			if msec < 0 {
+				msec = 10 // Check the drain progress periodically
+			}
+		}
 	}
 }
 
-func (ep *evPoll) scheduleTimer(eh EvHandler, delay, interval int64) error {
-	return ep.timer.schedule(eh, delay, interval)
+// startShutdown called by pollSyncOpt within the evPoll coroutine.
+// The earliest deadline wins if shutdown is requested more than once.
+func (ep *evPoll) startShutdown(deadline int64) {
+	if ep.shutdowning {
+		if deadline < 1 || (ep.shutdownDeadline > 0 && ep.shutdownDeadline <= deadline) {
+			return
+		}
+	}
+	ep.shutdowning = true
+	ep.shutdownDeadline = deadline
+}
+
+// shutdownStep closes acceptors and every EvHandler whose async write queue has been drained
+// (or all of them once the deadline has passed). Return true when the evPoll has been closed.
+func (ep *evPoll) shutdownStep() bool {
+	ep.asyncWrite.flush()
+
+	expired := ep.shutdownDeadline > 0 && time.Now().UnixMilli() >= ep.shutdownDeadline
+	pending := false
+	for _, ed := range ep.evHandlerMap.snapshot() {
+		if ed.fd == ep.timer.timerfd() || ed.fd == ep.asyncWrite.efd || ed.fd == ep.pollSyncOpterate.efd {
+			continue
+		}
+		if _, ok := ed.eh.(*Acceptor); !ok && !expired && !ed.eh.asyncWriteDrained() {
+			pending = true
+			continue
+		}
+		ep.remove(ed.fd, EvAll) // MUST before OnClose()
+		ed.eh.OnClose()
+	}
+	if pending {
+		return false
+	}
+	ep.close()
+	return true
 }
-func (ep *evPoll) cancelTimer(eh EvHandler) {
-	ep.timer.cancel(eh)
+
+// close releases all descriptors of the evPoll
+func (ep *evPoll) close() {
+	ep.asyncWrite.close()
+	ep.pollSyncOpterate.close()
+	ep.timer.close()
+	syscall.Close(ep.efd)
+	ep.efd = -1
+}
+
+// scheduleTimer schedules an internal timer which is not bound to any handler
+func (ep *evPoll) scheduleTimer(delay, interval int64, fn func(now int64) bool) (TimerID, error) {
+	return ep.timer.schedule(nil, fn, delay, interval)
+}
+func (ep *evPoll) cancelTimer(id TimerID) {
+	ep.timer.cancelID(id)
 }
 
 // poll sync opt
@@ -215,8 +337,40 @@ func (ep *evPoll) read(fd int) (bf []byte, n int, err error) {
 	}
 }
 
-func (ep *evPoll) push(awi asyncWriteItem) {
-	ep.asyncWrite.push(awi)
+// writev len(bufs) MUST <= ioVecMax
+func (ep *evPoll) writev(fd int, bufs [][]byte) (int, error) {
+	iovecs := ep.iovecs[:0]
+	for i := range bufs {
+		if len(bufs[i]) == 0 {
+			continue
+		}
+		iov := syscall.Iovec{Base: &bufs[i][0]}
+		iov.SetLen(len(bufs[i]))
+		iovecs = append(iovecs, iov)
+	}
+	if len(iovecs) == 0 {
+		return 0, nil
+	}
+	defer func() {
+		for i := range iovecs {
+			iovecs[i].Base = nil // release memory
+		}
+	}()
+	for {
+		n, _, errno := syscall.Syscall(syscall.SYS_WRITEV, uintptr(fd),
+			uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)))
+		if errno != 0 {
+			if errno == syscall.EINTR {
+				continue
+			}
+			return -1, errno
+		}
+		return int(n), nil
+	}
+}
+
+func (ep *evPoll) push(awi asyncWriteItem) bool {
+	return ep.asyncWrite.push(awi)
 }
 
 // end of `io handle'
@@ -84,3 +84,20 @@ func (dm *evDataMap) del(i int) {
 	delete(dm.sMap, i)
 	dm.mapMtx.Unlock()
 }
+
+// snapshot returns a copy of all registered items, so that the caller can
+// remove items while iterating
+func (dm *evDataMap) snapshot() []evData {
+	var l []evData
+	for i := 0; i < dm.arrSize; i++ {
+		if dm.arr[i].fd > 0 {
+			l = append(l, dm.arr[i])
+		}
+	}
+	dm.mapMtx.Lock()
+	for _, v := range dm.sMap {
+		l = append(l, *v)
+	}
+	dm.mapMtx.Unlock()
+	return l
+}
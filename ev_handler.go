@@ -39,14 +39,18 @@ const (
 // The same EvHandler is repeatedly registered with the Reactor
 type EvHandler interface {
 	setFd(fd int)
-	setParams(fd int, ep *evPoll)
+	setParams(fd int, ep *evPoll, eh EvHandler)
 	getEvPoll() *evPoll
 
 	setReactor(r *Reactor)
 	GetReactor() *Reactor
 
-	setTimerItem(ti *timerItem)
-	getTimerItem() *timerItem
+	setReleaseFunc(fn func())
+	setProxyHeader(ph *ProxyHeader)
+
+	addTimerItem(ti *timerItem)
+	delTimerItem(ti *timerItem)
+	timerItems() []*timerItem
 
 	// Fd return fd
 	Fd() int
@@ -81,6 +85,15 @@ type EvHandler interface {
 	// Remove timer when return false
 	OnTimeout(millisecond int64) bool
 
+	// OnIdle/OnReadTimeout/OnWriteTimeout called when the timeouts set by IOHandle.SetIdleTimeout/
+	// SetReadTimeout/SetWriteTimeout expire (within the evpoll coroutine), close the connection by
+	// default (Ignored in IOHandle).
+	//
+	// Call OnClose() when return false
+	OnIdle() bool
+	OnReadTimeout() bool
+	OnWriteTimeout() bool
+
 	// OnClose call by reactor(OnOpen must have been called before calling OnClose.)
 	//
 	// You need to manually release the fd resource call fd.Close()
@@ -95,21 +108,33 @@ type EvHandler interface {
 	//
 	// The data will be synchronously sent by evpoll within its coroutine.
 	// Each bf ensures ordered processing according to the sequence received by AsynWrite.
-	// Whenever a bf is processed (regardless of the send result), the OnAsyncWriteBufDone method is called.
+	// AsyncWrite copies bf, use AsyncWriteNoCopy to avoid it, then whenever a bf is processed
+	// (regardless of the send result), the OnAsyncWriteBufDone method is called.
 	// The framework strives to ensure timely data transmission and maintains order (
 	// data that fails to send will be stored in a separate queue and prioritized for the next
 	// transmission to ensure bf order).
 
-	// NOTE: Each bf invokes a syscall.Write once. The framework does not perform secondary assembly
-	// on bf (if needed, please assemble it manually)
+	// NOTE: Each bf invokes a syscall.Write once if it can be sent directly, the bfs waiting in the
+	// queue are coalesced into a single writev(2) syscall by AsyncOrderedFlush
 	AsyncWrite(eh EvHandler, buf []byte)
+	AsyncWriteNoCopy(eh EvHandler, buf []byte, flag int)
 	asyncOrderedWrite(ev EvHandler, abf asyncWriteBuf)
+	asyncWriteDrained() bool
+
+	// OnWriteBufferDrained called after all the data waiting to be sent has been sent
+	// by AsyncOrderedFlush (within the evpoll coroutine)
+	OnWriteBufferDrained()
+
+	// OnHighWatermark/OnLowWatermark called when the data waiting to be sent crosses the
+	// watermarks set by IOHandle.SetWriteWatermark (within the evpoll coroutine)
+	OnHighWatermark(size int)
+	OnLowWatermark(size int)
 
 	// OnAsyncWriteBufDone callback after bf used (within the evpoll coroutine),
 	// you can recycle bf. If no recycling is needed, you can ignore this method (Ignored in IOHandle).
 	//
-	// flag is passed by AsyncWrite{Flag}
-	//OnAsyncWriteBufDone(bf []byte, flag int)
+	// flag is passed by AsyncWriteNoCopy
+	OnAsyncWriteBufDone(bf []byte, flag int)
 
 	// Destroy If you are using the Async write mechanism, it is essential to call the Destroy method
 	// in OnClose to clean up any unsent bf data.
@@ -2,8 +2,9 @@ module github.com/shaovie/goev
 
 go 1.19
 
+require golang.org/x/sys v0.10.0
+
 require (
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616 // indirect
-	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/tools v0.11.1 // indirect
 )
@@ -0,0 +1,199 @@
+package goev
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Hot restart (zero downtime upgrade):
+//
+// The old process starts the new one by Reactor.ForkExec, the listening sockets of the
+// acceptors of the reactor are passed as fd 3, 4, ... with GOEV_LISTEN_FDS=n. NewAcceptor in
+// the new process takes the inherited fd bound to the same address instead of creating a new
+// socket, so the connections in the backlog are not lost. The old process then stops accepting
+// and drains the connections by Reactor.Shutdown (Reactor.Upgrade does both).
+// The inherited fds not taken are closed by CloseInheritedListeners.
+//
+// The systemd socket activation (LISTEN_FDS/LISTEN_PID) is supported in the same way.
+
+const (
+	envListenFds   = "GOEV_LISTEN_FDS"
+	listenFdsStart = 3 // SD_LISTEN_FDS_START
+)
+
+// listeners are the acceptors of the process, the ones of the reactor are passed to the new
+// process by ForkExec
+var listeners = struct {
+	mtx sync.Mutex
+	m   map[*Acceptor]struct{}
+}{m: make(map[*Acceptor]struct{})}
+
+func addListener(a *Acceptor) {
+	listeners.mtx.Lock()
+	listeners.m[a] = struct{}{}
+	listeners.mtx.Unlock()
+}
+func delListener(a *Acceptor) {
+	listeners.mtx.Lock()
+	delete(listeners.m, a)
+	listeners.mtx.Unlock()
+}
+
+// inherited are the listening fds passed by the parent process or systemd, not taken yet
+var inherited = struct {
+	once sync.Once
+	mtx  sync.Mutex
+	fds  []int
+}{}
+
+func loadInheritedFds() {
+	n, _ := strconv.Atoi(os.Getenv(envListenFds))
+	// Don't pass them to the child processes again
+	os.Unsetenv(envListenFds)
+	// refer to sd_listen_fds(3), they may target another process (e.g. the parent)
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		if n < 1 {
+			n, _ = strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		}
+		for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			os.Unsetenv(k)
+		}
+	}
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		syscall.CloseOnExec(fd)
+		inherited.fds = append(inherited.fds, fd)
+	}
+}
+
+// takeInheritedListener returns the inherited listening fd of type `typ' bound to sa,
+// return -1 if not found
+func takeInheritedListener(sa syscall.Sockaddr, typ int) int {
+	inherited.once.Do(loadInheritedFds)
+	inherited.mtx.Lock()
+	defer inherited.mtx.Unlock()
+	for i, fd := range inherited.fds {
+		if st, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE); err != nil ||
+			st != typ {
+			continue
+		}
+		if v, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN); err != nil || v != 1 {
+			continue
+		}
+		if lsa, err := syscall.Getsockname(fd); err != nil || !sockaddrEqual(sa, lsa) {
+			continue
+		}
+		inherited.fds = append(inherited.fds[:i], inherited.fds[i+1:]...)
+		return fd
+	}
+	return -1
+}
+
+// CloseInheritedListeners closes the inherited listening fds which are not taken by NewAcceptor
+// (e.g. the address is removed from the config of the new process), otherwise the clients in
+// their backlog hang. Call it after all the acceptors are created.
+//
+// It is safe for concurrent use by multiple goroutines
+func CloseInheritedListeners() {
+	inherited.once.Do(loadInheritedFds)
+	inherited.mtx.Lock()
+	defer inherited.mtx.Unlock()
+	for _, fd := range inherited.fds {
+		syscall.Close(fd)
+	}
+	inherited.fds = nil
+}
+
+func sockaddrEqual(a, b syscall.Sockaddr) bool {
+	switch a := a.(type) {
+	case *syscall.SockaddrInet4:
+		b, ok := b.(*syscall.SockaddrInet4)
+		return ok && a.Port == b.Port && a.Addr == b.Addr
+	case *syscall.SockaddrInet6:
+		b, ok := b.(*syscall.SockaddrInet6)
+		return ok && a.Port == b.Port && a.Addr == b.Addr
+	case *syscall.SockaddrUnix:
+		b, ok := b.(*syscall.SockaddrUnix)
+		return ok && a.Name == b.Name
+	}
+	return false
+}
+
+// ForkExec starts a new process with the listening sockets of the acceptors of the reactor
+// inherited (the other reactors' are not), then the acceptors created by NewAcceptor with
+// the same address in the new process use them. path is the executable of the new process
+// (e.g. the upgraded binary), the current executable and os.Args are used if path is empty.
+//
+// The caller should call Shutdown (or use Upgrade) to stop accepting and drain the connections,
+// the new process is not waited for.
+//
+// It is safe for concurrent use by multiple goroutines
+func (r *Reactor) ForkExec(path string, args ...string) (*os.Process, error) {
+	if path == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, errors.New("ForkExec: " + err.Error())
+		}
+		path = exe
+		if len(args) == 0 {
+			args = os.Args[1:]
+		}
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	listeners.mtx.Lock()
+	for a := range listeners.m {
+		fd := a.Fd()
+		if fd < 1 || a.reactor != r {
+			continue
+		}
+		// Dup it, os.File closes the fd
+		nfd, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+		if err != nil {
+			listeners.mtx.Unlock()
+			return nil, errors.New("ForkExec dup: " + err.Error())
+		}
+		files = append(files, os.NewFile(uintptr(nfd), "listener"))
+	}
+	listeners.mtx.Unlock()
+
+	env := make([]string, 0, len(os.Environ())+1)
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		if k == envListenFds || k == "LISTEN_PID" || k == "LISTEN_FDS" || k == "LISTEN_FDNAMES" {
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env, envListenFds+"="+strconv.Itoa(len(files)))
+
+	cmd := exec.Command(path, args...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files // fd 3, 4, ...
+	if err := cmd.Start(); err != nil {
+		return nil, errors.New("ForkExec: " + err.Error())
+	}
+	return cmd.Process, nil
+}
+
+// Upgrade starts the new process by ForkExec("") and then shuts down the reactor gracefully,
+// refer to Shutdown
+func (r *Reactor) Upgrade(ctx context.Context) error {
+	if _, err := r.ForkExec(""); err != nil {
+		return err
+	}
+	return r.Shutdown(ctx)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/shaovie/goev/netfd"
 )
@@ -18,19 +19,59 @@ type IOHandle struct {
 
 	r              *Reactor
 	ep             *evPoll
-	ti             *timerItem
+	eh             EvHandler // the object embedding IOHandle, set when registered with evPoll
+	tis            []*timerItem
+	wm             *writeWatermark
+	fc             *flushClose
+	to             *ioTimeouts
+	release        func() // Called once by Destroy, e.g. decrement the counters of Acceptor
+	ph             *ProxyHeader
 	asyncWriteBufQ *RingBuffer[asyncWriteBuf] // 保存未直接发送完成的
 }
 
 // Init IOHandle must be called when reusing it.
 func (h *IOHandle) Init() {
-	h.r, h.ep, h.ti = nil, nil, nil
+	h.r, h.ep, h.tis, h.eh, h.wm, h.fc, h.to = nil, nil, nil, nil, nil, nil, nil
+	h.asyncWriteBufSize = 0
+	h.release, h.ph = nil, nil
 	h.setFd(-1)
 }
 
-func (h *IOHandle) setParams(fd int, ep *evPoll) {
+func (h *IOHandle) setParams(fd int, ep *evPoll, eh EvHandler) {
 	h.setFd(fd)
 	h.ep = ep
+	h.eh = eh
+	if h.to != nil { // Set before registering
+		ep.push(asyncWriteItem{fn: h.armTimeouts})
+	}
+}
+
+func (h *IOHandle) setReleaseFunc(fn func()) {
+	h.release = fn
+}
+
+func (h *IOHandle) setProxyHeader(ph *ProxyHeader) {
+	h.ph = ph
+}
+
+// ProxyHeader returns the PROXY protocol header received by Acceptor (options.ProxyProtocol),
+// return nil if it's not enabled. ProxyHeader().SrcAddr is the real client address
+func (h *IOHandle) ProxyHeader() *ProxyHeader {
+	return h.ph
+}
+
+// PeerCred returns the credentials (pid, uid, gid) of the peer process of the unix socket
+// (SO_PEERCRED), taken when it called connect, for the local authorization
+func (h *IOHandle) PeerCred() (*syscall.Ucred, error) {
+	fd := h.Fd()
+	if fd < 1 {
+		return nil, syscall.EBADF
+	}
+	cred, err := syscall.GetsockoptUcred(fd, syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	if err != nil {
+		return nil, errors.New("Get SO_PEERCRED: " + err.Error())
+	}
+	return cred, nil
 }
 
 func (h *IOHandle) getEvPoll() *evPoll {
@@ -46,12 +87,45 @@ func (h *IOHandle) GetReactor() *Reactor {
 	return h.r
 }
 
-func (h *IOHandle) setTimerItem(ti *timerItem) {
-	h.ti = ti
+// EvPollIndex returns the index of the evPoll which the handler is registered with,
+// return -1 if it has not been added to the reactor yet
+func (h *IOHandle) EvPollIndex() int {
+	if h.ep != nil {
+		return h.ep.id
+	}
+	return -1
+}
+
+// Now returns the cached unix millisecond of the evPoll (refreshed after every epoll_wait returns),
+// it's cheaper than time.Now() and consistent with the timers.
+// Return the system time if it has not been added to the reactor yet
+//
+// Can only be used within the poller goroutine
+func (h *IOHandle) Now() int64 {
+	if h.ep != nil {
+		return h.ep.clock.now
+	}
+	return time.Now().UnixMilli()
+}
+
+func (h *IOHandle) addTimerItem(ti *timerItem) {
+	h.tis = append(h.tis, ti)
+}
+
+func (h *IOHandle) delTimerItem(ti *timerItem) {
+	for i := range h.tis {
+		if h.tis[i] == ti {
+			last := len(h.tis) - 1
+			h.tis[i] = h.tis[last]
+			h.tis[last] = nil
+			h.tis = h.tis[:last]
+			return
+		}
+	}
 }
 
-func (h *IOHandle) getTimerItem() *timerItem {
-	return h.ti
+func (h *IOHandle) timerItems() []*timerItem {
+	return h.tis
 }
 
 // Fd return fd
@@ -63,23 +137,53 @@ func (h *IOHandle) setFd(fd int) {
 }
 
 // ScheduleTimer Add a timer event to an IOHandle that is already registered with the reactor
-// to ensure that all event handling occurs within the same evpoll
+// to ensure that all event handling occurs within the same evpoll, eh.OnTimeout is called when
+// it expires. A handler can have multiple timers at the same time.
 //
 // Only supports binding timers to I/O objects within evpoll internally.
-func (h *IOHandle) ScheduleTimer(eh EvHandler, delay, interval int64) error {
+func (h *IOHandle) ScheduleTimer(eh EvHandler, delay, interval int64) (TimerID, error) {
 	if h.ep != nil {
-		return h.ep.scheduleTimer(eh, delay, interval)
+		return h.ep.timer.schedule(eh, nil, delay, interval)
 	}
-	return errors.New("ev handler has not been added to the reactor yet")
+	return 0, errors.New("ev handler has not been added to the reactor yet")
 }
 
-// CancelTimer cancels a timer that has been successfully scheduled
+// ScheduleTimerFunc is the same as ScheduleTimer, but fn is called instead of OnTimeout when
+// it expires (within the evpoll coroutine), remove the timer when fn returns false.
+// So the timers of a handler can have their own callbacks (e.g. heartbeat, request timeout, reconnect)
+func (h *IOHandle) ScheduleTimerFunc(delay, interval int64, fn func(now int64) bool) (TimerID, error) {
+	if fn == nil {
+		return 0, errors.New("ScheduleTimerFunc: fn is nil")
+	}
+	if h.ep != nil {
+		return h.ep.timer.schedule(h.eh, fn, delay, interval)
+	}
+	return 0, errors.New("ev handler has not been added to the reactor yet")
+}
+
+// CancelTimer cancels all the timers of eh that have been successfully scheduled
 func (h *IOHandle) CancelTimer(eh EvHandler) {
 	if h.ep != nil {
-		h.ep.cancelTimer(eh)
+		h.ep.timer.cancel(eh)
 	}
 }
 
+// CancelTimerID cancels the timer, it's ignored if the timer has expired (not periodic) or been canceled
+func (h *IOHandle) CancelTimerID(id TimerID) {
+	if h.ep != nil {
+		h.ep.timer.cancelID(id)
+	}
+}
+
+// ResetTimer changes the delay/interval of the timer in place (the next expiration is now+delay),
+// it can be called within the callback of the timer. Return error if the timer doesn't exist.
+func (h *IOHandle) ResetTimer(id TimerID, delay, interval int64) error {
+	if h.ep != nil {
+		return h.ep.timer.reset(id, delay, interval)
+	}
+	return errors.New("ev handler has not been added to the reactor yet")
+}
+
 // Read use evPollReadBuff, buf size can set by options.EvPollReadBuffSize
 //
 // Can only be used within the poller goroutine
@@ -89,7 +193,11 @@ func (h *IOHandle) Read() (bf []byte, n int, err error) {
 		return nil, 0, syscall.EBADF
 	}
 	if h.ep != nil {
-		return h.ep.read(fd)
+		bf, n, err = h.ep.read(fd)
+		if n > 0 {
+			h.touchRead()
+		}
+		return
 	}
 	panic("goev: IOHandle.Read fd not register to evpoll")
 }
@@ -124,6 +232,7 @@ func (h *IOHandle) Write(bf []byte) (n int, err error) {
 			buf: abf,
 		})
 		h.asyncWriteBufSize += n
+		h.onWriteBufGrow()
 		return
 	}
 	for {
@@ -136,6 +245,9 @@ func (h *IOHandle) Write(bf []byte) (n int, err error) {
 		}
 		break
 	}
+	if n > 0 {
+		h.touchWrite()
+	}
 	if n < len(bf) {
 		abf := ioAllocBuff(len(bf) - n)
 		n = copy(abf, bf[n:])
@@ -153,11 +265,87 @@ func (h *IOHandle) Write(bf []byte) (n int, err error) {
 			// eh needs to implement the OnWrite method, and the OnWrite method
 			// needs to call AsyncOrderedFlush.
 		}
+		h.touchWrite()
+		h.onWriteBufGrow()
 		n = len(bf)
 	}
 	return
 }
 
+// Writev synchronous scatter-gather write, bufs are sent by writev(2) (every ioVecMax
+// bufs a syscall), the unsent data is copied and queued like Write.
+// n = [0, sum(len(bufs[i]))]
+func (h *IOHandle) Writev(bufs [][]byte) (n int, err error) {
+	fd := h.Fd()
+	if fd < 1 { // NOTE fd must > 0
+		return 0, syscall.EBADF
+	}
+	total := 0
+	for i := range bufs {
+		total += len(bufs[i])
+	}
+	if h.asyncWriteBufQ != nil && !h.asyncWriteBufQ.IsEmpty() {
+		h.pushWriteBuf(fd, bufs, 0, total)
+		return total, nil
+	}
+	written := 0
+	for i := 0; i < len(bufs); i += ioVecMax {
+		end := i + ioVecMax
+		if end > len(bufs) {
+			end = len(bufs)
+		}
+		chunkLen := 0
+		for j := i; j < end; j++ {
+			chunkLen += len(bufs[j])
+		}
+		var m int
+		m, err = h.ep.writev(fd, bufs[i:end])
+		if m > 0 {
+			written += m
+			h.touchWrite()
+		}
+		if m < chunkLen {
+			break
+		}
+	}
+	if written < total {
+		h.pushWriteBuf(fd, bufs, written, total-written)
+	}
+	return total, err
+}
+
+// pushWriteBuf copies the data of bufs after skipping `skip' bytes into asyncWriteBufQ
+func (h *IOHandle) pushWriteBuf(fd int, bufs [][]byte, skip, size int) {
+	if size < 1 {
+		return
+	}
+	abf := ioAllocBuff(size)
+	n := 0
+	for i := range bufs {
+		bf := bufs[i]
+		if skip >= len(bf) {
+			skip -= len(bf)
+			continue
+		}
+		n += copy(abf[n:], bf[skip:])
+		skip = 0
+	}
+	if h.asyncWriteBufQ == nil {
+		h.asyncWriteBufQ = NewRingBuffer[asyncWriteBuf](2)
+	}
+	h.asyncWriteBufQ.PushBack(asyncWriteBuf{
+		len: n,
+		buf: abf,
+	})
+	h.asyncWriteBufSize += n
+	if h.asyncWriteWaiting == false {
+		h.asyncWriteWaiting = true
+		h.ep.append(fd, EvOut) // No need to use ET mode
+	}
+	h.touchWrite()
+	h.onWriteBufGrow()
+}
+
 // Destroy If you are using the Async write mechanism, it is essential to call the Destroy method
 // in OnClose to clean up any unsent bf data.
 func (h *IOHandle) Destroy(eh EvHandler) {
@@ -173,12 +361,32 @@ func (h *IOHandle) Destroy(eh EvHandler) {
 			if !ok {
 				break
 			}
-			ioFreeBuff(abf.buf)
+			abf.discard(eh)
 		}
 	}
+	h.asyncWriteBufSize = 0
+	if h.fc != nil {
+		h.stopFlushTimer()
+	}
+	if h.release != nil {
+		fn := h.release
+		h.release = nil
+		fn()
+	}
+}
+
+// closeInPoll removes the handler from evPoll and calls OnClose, used by the framework
+// within the evPoll coroutine (e.g. in a posted closure)
+func (h *IOHandle) closeInPoll() {
+	fd := h.Fd()
+	if fd < 1 || h.ep == nil || h.eh == nil {
+		return
+	}
+	h.ep.remove(fd, EvAll) // MUST before OnClose()
+	h.eh.OnClose()
 }
 
-// OnWriteBufferDrained called by asyncWriteBufQ drained
+// OnWriteBufferDrained called by asyncWriteBufQ drained (within the evpoll coroutine)
 func (h *IOHandle) OnWriteBufferDrained() {
 }
 
@@ -1,6 +1,8 @@
 package goev
 
 import (
+	"errors"
+	"net"
 	"syscall"
 )
 
@@ -20,10 +22,37 @@ type asyncWriteBuf struct {
 	writen int    // wrote len
 	len    int    // buf original len. readonly
 	buf    []byte // readonly
+	flag   int    // passed by AsyncWriteNoCopy
+	owned  bool   // buf is owned by the caller of AsyncWriteNoCopy, not allocated by ioAllocBuff
+
+	sf *sendFileItem // not nil if it's a file segment queued by SendFile
+}
+
+// release is called after buf is sent completely or discarded
+func (abf *asyncWriteBuf) release(eh EvHandler) {
+	if abf.owned {
+		eh.OnAsyncWriteBufDone(abf.buf, abf.flag)
+		return
+	}
+	ioFreeBuff(abf.buf)
+}
+
+// discard releases the unsent item
+func (abf *asyncWriteBuf) discard(eh EvHandler) {
+	if abf.sf != nil {
+		abf.sf.finish(syscall.ECANCELED)
+		return
+	}
+	abf.release(eh)
 }
 
+// ioVecMax refer to IOV_MAX in limits.h
+const ioVecMax = 1024
+
 // AsyncOrderedFlush only called in OnWrite
 //
+// The queued bufs are coalesced into a single writev(2) syscall (at most ioVecMax bufs a time)
+//
 // For example:
 //
 //	func (x *XX) OnWrite(fd int) {
@@ -31,33 +60,91 @@ type asyncWriteBuf struct {
 //	}
 func (h *IOHandle) AsyncOrderedFlush(eh EvHandler) {
 	fd := h.Fd()
-	if fd < 1 {
+	if fd < 1 || h.asyncWriteBufQ == nil {
 		return
 	}
-	n := h.asyncWriteBufQ.Len()
-	// It is necessary to use n to limit the number of sending attempts.
-	// If there is a possibility of sending failure, the data should be saved again in _asyncWriteBufQ
-	for i := 0; i < n; i++ {
-		abf, ok := h.asyncWriteBufQ.PopFront()
-		if !ok {
+	for !h.asyncWriteBufQ.IsEmpty() {
+		abf, _ := h.asyncWriteBufQ.PopFront()
+		if abf.sf != nil { // The file segment queued by SendFile
+			left := abf.sf.count
+			done, err := abf.sf.send(fd)
+			if n := int(left - abf.sf.count); n > 0 {
+				h.asyncWriteBufSize -= n
+				h.touchWrite()
+			}
+			if err != nil {
+				abf.sf.finish(err)
+				h.abortWrite(eh)
+				return
+			}
+			if done {
+				abf.sf.finish(nil)
+				h.onWriteBufShrink()
+				continue
+			}
+			h.onWriteBufShrink()
+			h.asyncWriteBufQ.PushFront(abf)
 			break
 		}
-		n, _ := syscall.Write(fd, abf.buf[abf.writen:abf.len])
+		h.asyncWriteBufQ.PushFront(abf)
+
+		abfs, bufs := h.ep.iovAbufs[:0], h.ep.iovBufs[:0]
+		size := 0
+		for len(abfs) < ioVecMax {
+			abf, ok := h.asyncWriteBufQ.PopFront()
+			if !ok {
+				break
+			}
+			if abf.sf != nil { // Stop at the file segment
+				h.asyncWriteBufQ.PushFront(abf)
+				break
+			}
+			abfs = append(abfs, abf)
+			bufs = append(bufs, abf.buf[abf.writen:abf.len])
+			size += abf.len - abf.writen
+		}
+		n, _ := h.ep.writev(fd, bufs)
+		if n < 0 {
+			n = 0
+		}
+		h.asyncWriteBufSize -= n
 		if n > 0 {
-			h.asyncWriteBufSize -= n
-			if n == (abf.len - abf.writen) { // send completely
-				ioFreeBuff(abf.buf)
-				continue
+			h.touchWrite()
+		}
+		h.onWriteBufShrink()
+		sent := n
+
+		// If there is a possibility of sending failure, the data should be saved again in _asyncWriteBufQ
+		i := 0
+		for ; i < len(abfs); i++ {
+			left := abfs[i].len - abfs[i].writen
+			if n < left {
+				abfs[i].writen += n // Partially write, shift n
+				break
 			}
-			abf.writen += n // Partially write, shift n
+			n -= left
+			abfs[i].release(eh) // send completely
+		}
+		for j := len(abfs) - 1; j >= i; j-- {
+			h.asyncWriteBufQ.PushFront(abfs[j])
+		}
+		for j := range abfs {
+			abfs[j] = asyncWriteBuf{} // release memory
+			bufs[j] = nil
+		}
+		if sent < size {
+			break
 		}
-		h.asyncWriteBufQ.PushFront(abf)
-		break
 	}
 	if h.asyncWriteBufQ.IsEmpty() {
 		h.ep.remove(fd, EvOut)
 		h.asyncWriteWaiting = false
-		h.OnWriteBufferDrained()
+		if h.eh != nil {
+			h.eh.OnWriteBufferDrained()
+		}
+		if h.fc != nil && h.Fd() > 0 {
+			h.flushDone()
+		}
 	}
 }
 
@@ -84,23 +171,81 @@ func (h *IOHandle) AsyncWrite(eh EvHandler, buf []byte) {
 	})
 }
 
+// AsyncWriteNoCopy asynchronous write without copying buf, the framework takes the ownership of buf,
+// and calls eh.OnAsyncWriteBufDone(buf, flag) within the evpoll coroutine after buf is sent
+// completely or discarded by Destroy, then you can recycle buf (e.g. put it back to your pool).
+// buf must not be modified before that. It's better for large payloads than AsyncWrite.
+//
+// NOTE: If the handler has been closed or the reactor has been stopped, OnAsyncWriteBufDone
+// is called immediately in the calling goroutine.
+//
+// It is safe for concurrent use by multiple goroutines
+func (h *IOHandle) AsyncWriteNoCopy(eh EvHandler, buf []byte, flag int) {
+	fd := h.Fd()
+	if fd < 1 { // NOTE fd must > 0
+		eh.OnAsyncWriteBufDone(buf, flag)
+		return
+	}
+	if fd != eh.Fd() { // Ensure that it is the same object
+		panic("goev: AsyncWriteNoCopy EvHandler is invalid")
+	}
+	h.ep.push(asyncWriteItem{
+		fd: fd,
+		eh: eh,
+		abf: asyncWriteBuf{
+			len:   len(buf),
+			buf:   buf,
+			flag:  flag,
+			owned: true,
+		},
+	})
+}
+
+// OnAsyncWriteBufDone callback after the buf of AsyncWriteNoCopy used, refer to EvHandler
+func (h *IOHandle) OnAsyncWriteBufDone(bf []byte, flag int) {
+}
+
+// Post queues fn to the evPoll which the handler is registered with, fn will be called within
+// the evPoll coroutine, so it can safely call Write, ScheduleTimer, CancelTimer etc. of the handler.
+// fn and the data of AsyncWrite are processed in the order in which they were queued.
+//
+// It returns net.ErrClosed if the handler has been closed or the evPoll has been stopped,
+// fn will never be called then.
+//
+// It is safe for concurrent use by multiple goroutines
+// NOTE: The handler may also be closed before fn is called, check Fd() if needed
+func (h *IOHandle) Post(fn func()) error {
+	if fn == nil {
+		return errors.New("Post: fn is nil")
+	}
+	if h.ep == nil {
+		return errors.New("ev handler has not been added to the reactor yet")
+	}
+	if h.Fd() < 1 || !h.ep.push(asyncWriteItem{fn: fn}) {
+		return net.ErrClosed
+	}
+	return nil
+}
+
 func (h *IOHandle) asyncOrderedWrite(eh EvHandler, abf asyncWriteBuf) {
 	fd := h.Fd()
 	if fd < 1 { // closed or except
-		ioFreeBuff(abf.buf)
+		abf.release(eh)
 		return
 	}
 	h.asyncWriteBufSize += abf.len
 	if h.asyncWriteBufQ != nil && !h.asyncWriteBufQ.IsEmpty() {
 		h.asyncWriteBufQ.PushBack(abf)
+		h.onWriteBufGrow()
 		return
 	}
 
 	n, _ := syscall.Write(fd, abf.buf[abf.writen:abf.len])
 	if n > 0 {
+		h.touchWrite()
 		h.asyncWriteBufSize -= n
 		if n == (abf.len - abf.writen) {
-			ioFreeBuff(abf.buf)
+			abf.release(eh)
 			return
 		}
 		abf.writen += n // Partially write, shift n
@@ -118,6 +263,8 @@ func (h *IOHandle) asyncOrderedWrite(eh EvHandler, abf asyncWriteBuf) {
 		// eh needs to implement the OnWrite method, and the OnWrite method
 		// needs to call AsyncOrderedFlush.
 	}
+	h.touchWrite()
+	h.onWriteBufGrow()
 }
 
 // AsyncWaitWriteQLen The length of the queue waiting to be sent asynchronously
@@ -129,3 +276,7 @@ func (h *IOHandle) AsyncWaitWriteQLen() int {
 	}
 	return h.asyncWriteBufQ.Len()
 }
+
+func (h *IOHandle) asyncWriteDrained() bool {
+	return h.asyncWriteBufQ == nil || h.asyncWriteBufQ.IsEmpty()
+}
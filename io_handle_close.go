@@ -0,0 +1,113 @@
+package goev
+
+import (
+	"syscall"
+)
+
+const (
+	flushThenShutdown = 1 // ShutdownWrite
+	flushThenClose    = 2 // CloseAfterFlush
+)
+
+// flushClose is the state of CloseAfterFlush/ShutdownWrite
+type flushClose struct {
+	mode  int
+	timer TimerID
+}
+
+// CloseAfterFlush stops reading, and closes the connection after all the data waiting to be sent
+// (Write/Writev/AsyncWrite/SendFile) has been sent: shutdown(SHUT_WR), then OnClose is called
+// within the evpoll coroutine. So the final response (e.g. HTTP `Connection: close') is delivered
+// reliably, instead of being discarded by Destroy.
+//
+// If the data can't be sent within timeout milliseconds, the connection is closed anyway.
+// No timeout if timeout equal 0.
+//
+// OnWrite must call AsyncOrderedFlush. Don't return false from OnRead after calling it (that closes
+// immediately). Can only be used within the poller goroutine
+func (h *IOHandle) CloseAfterFlush(timeout int64) {
+	fd := h.Fd()
+	if fd < 1 || h.ep == nil {
+		return
+	}
+	if h.fc != nil && h.fc.mode == flushThenClose {
+		return
+	}
+	h.startFlushClose(flushThenClose, timeout)
+	if h.asyncWriteDrained() {
+		h.flushDone()
+		return
+	}
+	h.ep.disableRead(fd)
+}
+
+// ShutdownWrite half-closes the connection after all the data waiting to be sent has been sent
+// (shutdown(SHUT_WR)), the peer will read EOF, but we can continue to read the data from it.
+//
+// If the data can't be sent within timeout milliseconds, it's shut down anyway.
+// No timeout if timeout equal 0.
+//
+// OnWrite must call AsyncOrderedFlush. Can only be used within the poller goroutine
+func (h *IOHandle) ShutdownWrite(timeout int64) {
+	fd := h.Fd()
+	if fd < 1 || h.ep == nil || h.fc != nil {
+		return
+	}
+	if h.asyncWriteDrained() {
+		syscall.Shutdown(fd, syscall.SHUT_WR)
+		return
+	}
+	h.startFlushClose(flushThenShutdown, timeout)
+}
+
+func (h *IOHandle) startFlushClose(mode int, timeout int64) {
+	if h.fc != nil {
+		h.stopFlushTimer()
+	}
+	fc := &flushClose{mode: mode}
+	h.fc = fc
+	if timeout > 0 {
+		fc.timer, _ = h.ep.scheduleTimer(timeout, 0, func(int64) bool {
+			fc.timer = 0
+			if h.fc == fc { // The pending data is not sent in time, give up
+				h.flushDone()
+			}
+			return false
+		})
+	}
+}
+
+func (h *IOHandle) stopFlushTimer() {
+	if h.fc.timer != 0 {
+		h.ep.cancelTimer(h.fc.timer)
+		h.fc.timer = 0
+	}
+}
+
+// flushDone is called when the pending data has been sent or timeout
+func (h *IOHandle) flushDone() {
+	fc := h.fc
+	h.stopFlushTimer()
+	fd := h.Fd()
+	if fd < 1 {
+		h.fc = nil
+		return
+	}
+	syscall.Shutdown(fd, syscall.SHUT_WR)
+	if fc.mode == flushThenShutdown {
+		h.fc = nil
+		return
+	}
+	// Don't close it synchronously, we may be in OnRead/OnWrite
+	h.ep.push(asyncWriteItem{fn: func() {
+		if fc == h.fc { // Not Init() for reusing
+			h.fc = nil
+			h.closeInPoll()
+		}
+	}})
+}
+
+// readStopped returns true if CloseAfterFlush has been called
+func (h *IOHandle) readStopped() bool {
+	return h.fc != nil && h.fc.mode == flushThenClose
+}
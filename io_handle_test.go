@@ -0,0 +1,523 @@
+package goev
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type writevConn struct {
+	IOHandle
+}
+
+func (c *writevConn) OnRead() bool {
+	_, n, _ := c.Read()
+	return n != 0
+}
+func (c *writevConn) OnWrite() bool {
+	c.AsyncOrderedFlush(c)
+	return true
+}
+func (c *writevConn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestIOHandleWritev(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+	syscall.SetsockoptInt(fds[0], syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+
+	c := &writevConn{}
+	if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+		t.Fatal(err)
+	}
+	var want []byte
+	var bufs [][]byte
+	for i := 0; i < 2000; i++ { // > ioVecMax
+		bf := bytes.Repeat([]byte{byte(i)}, i%97+1)
+		bufs = append(bufs, bf)
+		want = append(want, bf...)
+	}
+	c.Post(func() {
+		c.Writev(bufs[:1000])
+		c.Writev(bufs[1000:]) // Queued if the previous one is not sent completely
+	})
+
+	var got []byte
+	buf := make([]byte, 1024)
+	deadline := time.Now().Add(time.Second * 2)
+	for len(got) < len(want) && time.Now().Before(deadline) {
+		n, _ := syscall.Read(fds[1], buf)
+		if n > 0 {
+			got = append(got, buf[:n]...)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("writev data mismatch, got %d bytes, want %d bytes", len(got), len(want))
+	}
+}
+
+func TestIOHandleSendFile(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	f, err := os.CreateTemp("", "goev-sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+	syscall.SetsockoptInt(fds[0], syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+
+	c := &writevConn{}
+	if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+		t.Fatal(err)
+	}
+	doneC := make(chan int64, 1)
+	c.Post(func() {
+		c.Write([]byte("head"))
+		c.SendFile(f, 16, int64(len(content)-32), func(sent int64, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			doneC <- sent
+		})
+		c.Write([]byte("tail")) // Queued after the file segment
+	})
+	want := append(append([]byte("head"), content[16:len(content)-16]...), "tail"...)
+
+	var got []byte
+	buf := make([]byte, 64*1024)
+	deadline := time.Now().Add(time.Second * 2)
+	for len(got) < len(want) && time.Now().Before(deadline) {
+		n, _ := syscall.Read(fds[1], buf)
+		if n > 0 {
+			got = append(got, buf[:n]...)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("sendfile data mismatch, got %d bytes, want %d bytes", len(got), len(want))
+	}
+	select {
+	case sent := <-doneC:
+		if sent != int64(len(content)-32) {
+			t.Fatalf("sendfile sent %d, want %d", sent, len(content)-32)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sendfile done callback not called")
+	}
+
+	// Fails at once, the error is returned
+	wf, err := os.OpenFile(f.Name(), os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wf.Close()
+	errC := make(chan error, 1)
+	c.Post(func() {
+		errC <- c.SendFile(wf, 0, 16, func(int64, error) { t.Error("done called") })
+	})
+	if err = <-errC; err == nil {
+		t.Fatal("SendFile returns nil")
+	}
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestIOHandleSendFileTruncated(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	f, err := os.CreateTemp("", "goev-sendfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+	syscall.SetsockoptInt(fds[0], syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+
+	c := &watermarkConn{closedC: make(chan struct{})}
+	if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+		t.Fatal(err)
+	}
+	errC := make(chan error, 1)
+	c.Post(func() {
+		c.Write([]byte("head"))
+		// The file is shorter than count
+		c.SendFile(f, 0, int64(len(content)+1024), func(sent int64, err error) {
+			errC <- err
+		})
+		if c.WriteBufferedSize() < 1024 {
+			t.Errorf("buffered size %d doesn't include the file segment", c.WriteBufferedSize())
+		}
+		c.Write([]byte("tail")) // Must not be sent after the truncated file
+	})
+	want := append([]byte("head"), content...)
+
+	var got []byte
+	buf := make([]byte, 64*1024)
+	deadline := time.Now().Add(time.Second * 2)
+	for time.Now().Before(deadline) {
+		n, err := syscall.Read(fds[1], buf)
+		if n > 0 {
+			got = append(got, buf[:n]...)
+		} else if n == 0 && err == nil { // EOF
+			break
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("sendfile data mismatch, got %d bytes, want %d bytes", len(got), len(want))
+	}
+	select {
+	case err := <-errC:
+		if err == nil {
+			t.Fatal("sendfile should fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sendfile done callback not called")
+	}
+	select {
+	case <-c.closedC:
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+}
+
+type watermarkConn struct {
+	writevConn
+
+	highC   chan int
+	lowC    chan int
+	closedC chan struct{}
+}
+
+func (c *watermarkConn) OnHighWatermark(size int) { c.highC <- size }
+func (c *watermarkConn) OnLowWatermark(size int)  { c.lowC <- size }
+func (c *watermarkConn) OnWrite() bool {
+	c.AsyncOrderedFlush(c)
+	return true
+}
+func (c *watermarkConn) OnClose() {
+	c.Destroy(c)
+	close(c.closedC)
+}
+
+func TestIOHandleWriteWatermark(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	newConn := func() (*watermarkConn, int) {
+		fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		syscall.SetsockoptInt(fds[0], syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+		c := &watermarkConn{highC: make(chan int, 1), lowC: make(chan int, 1), closedC: make(chan struct{})}
+		if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+			t.Fatal(err)
+		}
+		return c, fds[1]
+	}
+
+	c, peer := newConn()
+	defer syscall.Close(peer)
+	c.Post(func() {
+		c.SetWriteWatermark(32*1024, 1024, true)
+		for i := 0; i < 64; i++ {
+			c.Write(bytes.Repeat([]byte{'a'}, 1024))
+		}
+	})
+	select {
+	case size := <-c.highC:
+		if size <= 32*1024 {
+			t.Fatalf("high watermark size %d", size)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnHighWatermark not called")
+	}
+	buf := make([]byte, 64*1024)
+	deadline := time.After(time.Second * 2)
+	for done := false; !done; {
+		syscall.Read(peer, buf)
+		select {
+		case size := <-c.lowC:
+			if size > 1024 {
+				t.Fatalf("low watermark size %d", size)
+			}
+			done = true
+		case <-deadline:
+			t.Fatal("OnLowWatermark not called")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	// Hard cap
+	c2, peer2 := newConn()
+	defer syscall.Close(peer2)
+	c2.Post(func() {
+		c2.SetWriteBufferLimit(16 * 1024)
+		for i := 0; i < 64; i++ {
+			c2.Write(bytes.Repeat([]byte{'a'}, 1024))
+		}
+	})
+	select {
+	case <-c2.closedC:
+	case <-time.After(time.Second):
+		t.Fatal("the connection exceeding the write buffer limit is not closed")
+	}
+}
+
+func TestIOHandleCloseAfterFlush(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	newConn := func() (*watermarkConn, int) {
+		fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		syscall.SetsockoptInt(fds[0], syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+		c := &watermarkConn{highC: make(chan int, 1), lowC: make(chan int, 1), closedC: make(chan struct{})}
+		if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+			t.Fatal(err)
+		}
+		return c, fds[1]
+	}
+
+	c, peer := newConn()
+	defer syscall.Close(peer)
+	want := bytes.Repeat([]byte("0123456789"), 8*1024)
+	c.Post(func() {
+		c.Write(want)
+		c.CloseAfterFlush(0)
+	})
+	time.Sleep(time.Millisecond * 20)
+	select {
+	case <-c.closedC:
+		t.Fatal("closed before the data is sent")
+	default:
+	}
+	var got []byte
+	buf := make([]byte, 64*1024)
+	deadline := time.Now().Add(time.Second * 2)
+	for time.Now().Before(deadline) {
+		n, _ := syscall.Read(peer, buf)
+		if n == 0 { // EOF
+			break
+		}
+		if n > 0 {
+			got = append(got, buf[:n]...)
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("data mismatch, got %d bytes, want %d bytes", len(got), len(want))
+	}
+	select {
+	case <-c.closedC:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not called after flushing")
+	}
+
+	// Timeout
+	c2, peer2 := newConn()
+	defer syscall.Close(peer2)
+	c2.Post(func() {
+		c2.Write(want)
+		c2.CloseAfterFlush(50)
+	})
+	select {
+	case <-c2.closedC:
+	case <-time.After(time.Second):
+		t.Fatal("OnClose not called after timeout")
+	}
+}
+
+type noCopyConn struct {
+	writevConn
+
+	doneC chan int
+}
+
+func (c *noCopyConn) OnAsyncWriteBufDone(bf []byte, flag int) {
+	c.doneC <- flag
+}
+func (c *noCopyConn) OnWrite() bool {
+	c.AsyncOrderedFlush(c)
+	return true
+}
+func (c *noCopyConn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestIOHandleAsyncWriteNoCopy(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fds[1])
+	syscall.SetsockoptInt(fds[0], syscall.SOL_SOCKET, syscall.SO_SNDBUF, 4096)
+
+	const num = 32
+	c := &noCopyConn{doneC: make(chan int, num)}
+	if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+		t.Fatal(err)
+	}
+	bf := bytes.Repeat([]byte{'a'}, 8*1024)
+	for i := 0; i < num; i++ {
+		c.AsyncWriteNoCopy(c, bf, i)
+	}
+	// Some are sent, the rest are discarded by Destroy
+	time.Sleep(time.Millisecond * 20)
+	syscall.Shutdown(fds[1], syscall.SHUT_RDWR)
+
+	for i := 0; i < num; i++ {
+		select {
+		case flag := <-c.doneC:
+			if flag != i {
+				t.Fatalf("OnAsyncWriteBufDone flag %d, want %d", flag, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("OnAsyncWriteBufDone called %d times, want %d", i, num)
+		}
+	}
+}
+
+type timeoutConn struct {
+	IOHandle
+
+	readTimeoutC chan struct{}
+	closedC      chan struct{}
+}
+
+func (c *timeoutConn) OnRead() bool {
+	_, n, _ := c.Read()
+	return n != 0
+}
+func (c *timeoutConn) OnReadTimeout() bool {
+	close(c.readTimeoutC)
+	return false
+}
+func (c *timeoutConn) OnClose() {
+	c.Destroy(c)
+	close(c.closedC)
+}
+
+func TestIOHandleTimeout(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	newConn := func(set func(c *timeoutConn)) (*timeoutConn, int) {
+		fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &timeoutConn{readTimeoutC: make(chan struct{}), closedC: make(chan struct{})}
+		set(c) // Before registering
+		if err = r.AddEvHandler(c, fds[0], EvIn); err != nil {
+			t.Fatal(err)
+		}
+		return c, fds[1]
+	}
+
+	// Read timeout
+	begin := time.Now()
+	c, peer := newConn(func(c *timeoutConn) { c.SetReadTimeout(600) })
+	defer syscall.Close(peer)
+	select {
+	case <-c.closedC:
+		if d := time.Since(begin); d < 500*time.Millisecond {
+			t.Fatalf("read timeout fired after %s", d)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("read timeout not fired")
+	}
+	select {
+	case <-c.readTimeoutC:
+	default:
+		t.Fatal("OnReadTimeout not called")
+	}
+
+	// Idle timeout is refreshed by reading
+	c2, peer2 := newConn(func(c *timeoutConn) { c.SetIdleTimeout(600) })
+	defer syscall.Close(peer2)
+	for i := 0; i < 8; i++ {
+		syscall.Write(peer2, []byte("ping"))
+		time.Sleep(200 * time.Millisecond)
+	}
+	select {
+	case <-c2.closedC:
+		t.Fatal("idle timeout fired while reading")
+	default:
+	}
+	select {
+	case <-c2.closedC:
+	case <-time.After(3 * time.Second):
+		t.Fatal("idle timeout not fired")
+	}
+}
@@ -0,0 +1,273 @@
+package goev
+
+const (
+	// idleWheelTick is the accuracy of the idle/read/write timeouts (millisecond)
+	idleWheelTick = 500
+
+	// idleWheelSlots the timeouts longer than idleWheelTick*idleWheelSlots are re-checked periodically
+	idleWheelSlots = 512
+)
+
+// ioTimeouts is the idle/read/write timeout state of IOHandle.
+// The last activity time is refreshed by a plain store, the wheel entry is checked lazily
+type ioTimeouts struct {
+	idle  int64 // millisecond, 0 means disabled
+	read  int64
+	write int64
+
+	lastRead  int64 // the last time of reading data
+	lastWrite int64 // the last time of writing data or the data becoming pending
+
+	w   *idleWheel
+	due int64  // the time of the wheel slot, 0 means not in the wheel
+	gen uint32 // the entries in the wheel with another gen are stale
+	h   *IOHandle
+}
+
+type idleWheelEntry struct {
+	to  *ioTimeouts
+	gen uint32
+}
+
+// idleWheel is a coarse timing wheel per evPoll for the idle/read/write timeouts,
+// so the connections don't each hold a heap entry in timer4Heap
+type idleWheel struct {
+	ep      *evPoll
+	now     int64 // unix millisecond, updated every tick
+	cur     int
+	num     int // entries in the wheel, including the stale ones
+	running bool
+	slots   [idleWheelSlots][]idleWheelEntry
+	scratch []idleWheelEntry
+}
+
+func (ep *evPoll) getIdleWheel() *idleWheel {
+	if ep.idleWheel == nil {
+		ep.idleWheel = &idleWheel{ep: ep, now: ep.clock.now}
+	}
+	return ep.idleWheel
+}
+
+// coarseNow returns the time of the last tick, it's stale if the wheel is stopped
+func (w *idleWheel) coarseNow() int64 {
+	if w.running {
+		return w.now
+	}
+	return w.ep.clock.now
+}
+
+func (w *idleWheel) add(to *ioTimeouts, deadline int64) {
+	if !w.running {
+		w.now = w.ep.clock.now
+	}
+	ticks := (deadline - w.now + idleWheelTick - 1) / idleWheelTick
+	if ticks < 1 {
+		ticks = 1
+	} else if ticks >= idleWheelSlots {
+		ticks = idleWheelSlots - 1
+	}
+	to.gen++
+	to.due = w.now + ticks*idleWheelTick
+	idx := (w.cur + int(ticks)) % idleWheelSlots
+	w.slots[idx] = append(w.slots[idx], idleWheelEntry{to: to, gen: to.gen})
+	w.num++
+	if !w.running {
+		if _, err := w.ep.scheduleTimer(idleWheelTick, idleWheelTick, w.tick); err == nil {
+			w.running = true
+		}
+	}
+}
+
+// tick advances the wheel
+func (w *idleWheel) tick(now int64) bool {
+	w.now = now
+	w.cur = (w.cur + 1) % idleWheelSlots
+	entries := w.slots[w.cur]
+	w.slots[w.cur] = w.scratch[:0]
+	w.num -= len(entries)
+	for i := range entries {
+		e := entries[i]
+		entries[i] = idleWheelEntry{}
+		if e.gen == e.to.gen {
+			e.to.due = 0
+			e.to.check(now)
+		}
+	}
+	w.scratch = entries[:0]
+	if w.num == 0 {
+		w.running = false
+		return false
+	}
+	return true
+}
+
+// check fires the expired timeouts and reschedules the next one
+func (to *ioTimeouts) check(now int64) {
+	h := to.h
+	if h.to != to || h.Fd() < 1 || h.eh == nil { // Closed or reused
+		return
+	}
+	if to.idle > 0 {
+		last := to.lastRead
+		if to.lastWrite > last {
+			last = to.lastWrite
+		}
+		if last+to.idle <= now {
+			if h.eh.OnIdle() == false {
+				h.closeInPoll()
+				return
+			}
+			to.lastRead, to.lastWrite = now, now
+		}
+	}
+	if to.read > 0 && to.lastRead+to.read <= now {
+		if h.eh.OnReadTimeout() == false {
+			h.closeInPoll()
+			return
+		}
+		to.lastRead = now
+	}
+	if to.write > 0 && !h.asyncWriteDrained() && to.lastWrite+to.write <= now {
+		if h.eh.OnWriteTimeout() == false {
+			h.closeInPoll()
+			return
+		}
+		to.lastWrite = now
+	}
+	to.schedule()
+}
+
+// next returns the earliest deadline, 0 if there is none
+func (to *ioTimeouts) next() int64 {
+	var deadline int64
+	min := func(d int64) {
+		if deadline == 0 || d < deadline {
+			deadline = d
+		}
+	}
+	if to.idle > 0 {
+		last := to.lastRead
+		if to.lastWrite > last {
+			last = to.lastWrite
+		}
+		min(last + to.idle)
+	}
+	if to.read > 0 {
+		min(to.lastRead + to.read)
+	}
+	if to.write > 0 && !to.h.asyncWriteDrained() {
+		min(to.lastWrite + to.write)
+	}
+	return deadline
+}
+
+// schedule makes sure the wheel entry is not later than the earliest deadline
+func (to *ioTimeouts) schedule() {
+	if to.w == nil || to.h.Fd() < 1 {
+		return
+	}
+	deadline := to.next()
+	if deadline == 0 || (to.due != 0 && to.due <= deadline) {
+		return
+	}
+	to.w.add(to, deadline)
+}
+
+// arm starts the timeouts, within the evpoll coroutine
+func (h *IOHandle) armTimeouts() {
+	to := h.to
+	if to == nil || h.ep == nil || h.Fd() < 1 {
+		return
+	}
+	if to.w == nil {
+		to.w = h.ep.getIdleWheel()
+		now := h.ep.clock.now
+		to.lastRead, to.lastWrite = now, now
+	}
+	to.schedule()
+}
+
+const (
+	timeoutIdle = iota
+	timeoutRead
+	timeoutWrite
+)
+
+func (h *IOHandle) setTimeout(kind int, msec int64) {
+	if msec < 0 {
+		panic("goev:IOHandle timeout param is illegal")
+	}
+	if h.to == nil {
+		if msec == 0 {
+			return
+		}
+		h.to = &ioTimeouts{h: h}
+	}
+	switch kind {
+	case timeoutIdle:
+		h.to.idle = msec
+	case timeoutRead:
+		h.to.read = msec
+	case timeoutWrite:
+		h.to.write = msec
+	}
+	if h.ep != nil { // Registered
+		h.armTimeouts()
+	}
+}
+
+// touchRead refreshes the last reading time
+func (h *IOHandle) touchRead() {
+	if to := h.to; to != nil && to.w != nil {
+		to.lastRead = to.w.coarseNow()
+	}
+}
+
+// touchWrite refreshes the last writing time, called after writing data or the data becoming pending
+func (h *IOHandle) touchWrite() {
+	if to := h.to; to != nil && to.w != nil {
+		to.lastWrite = to.w.coarseNow()
+		if to.write > 0 {
+			to.schedule()
+		}
+	}
+}
+
+// SetIdleTimeout OnIdle is called if no data is read or written for msec milliseconds.
+// The accuracy is 500 milliseconds. It's disabled if msec equal 0.
+//
+// It can be called before the handler is registered with the reactor (e.g. in OnOpen before
+// AddEvHandler), or within the poller goroutine
+func (h *IOHandle) SetIdleTimeout(msec int64) {
+	h.setTimeout(timeoutIdle, msec)
+}
+
+// SetReadTimeout OnReadTimeout is called if no data is read for msec milliseconds,
+// refer to SetIdleTimeout
+func (h *IOHandle) SetReadTimeout(msec int64) {
+	h.setTimeout(timeoutRead, msec)
+}
+
+// SetWriteTimeout OnWriteTimeout is called if there is data waiting to be sent, but none of it
+// can be sent for msec milliseconds (e.g. the peer doesn't read), refer to SetIdleTimeout
+func (h *IOHandle) SetWriteTimeout(msec int64) {
+	h.setTimeout(timeoutWrite, msec)
+}
+
+// OnIdle called when the idle timeout expires (within the evpoll coroutine),
+// close the connection by default. Refer to SetIdleTimeout
+//
+// Call OnClose() when return false
+func (h *IOHandle) OnIdle() bool {
+	return false
+}
+
+// OnReadTimeout called when the read timeout expires, refer to OnIdle
+func (h *IOHandle) OnReadTimeout() bool {
+	return false
+}
+
+// OnWriteTimeout called when the write timeout expires, refer to OnIdle
+func (h *IOHandle) OnWriteTimeout() bool {
+	return false
+}
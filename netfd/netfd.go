@@ -41,44 +41,48 @@ func Close(fd int) error {
 
 // LocalAddr retrieves the local address of the specified socket file descriptor (fd).
 //
-// Return format 192.168.0.1:8080
+// Return format 192.168.0.1:8080 or [::1]:8080
 // Return "", if error
 func LocalAddr(fd int) string {
-	sa, _ := syscall.Getsockname(fd)
-	ip := net.IP{}
-	port := 0
-	switch sa := sa.(type) {
-	case *syscall.SockaddrInet4:
-		copy([]byte(ip), sa.Addr[0:])
-		port = sa.Port
-	case *syscall.SockaddrInet6:
-		copy([]byte(ip), sa.Addr[0:])
-		port = sa.Port
-	default:
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
 		return ""
 	}
-	return ip.String() + ":" + strconv.FormatInt(int64(port), 10)
+	return SockaddrString(sa)
 }
 
 // RemoteAddr retrieves the remote address of the specified socket file descriptor (fd).
 //
-// Return format 192.168.0.1:8080
+// Return format 192.168.0.1:8080 or [::1]:8080
 // Return "", if error
 func RemoteAddr(fd int) string {
-	sa, _ := syscall.Getpeername(fd)
-	ip := net.IP{}
-	port := 0
+	sa, err := syscall.Getpeername(fd)
+	if err != nil {
+		return ""
+	}
+	return SockaddrString(sa)
+}
+
+// SockaddrString formats the inet sockaddr.
+//
+// Return format 192.168.0.1:8080 or [::1]:8080 or [fe80::1%eth0]:8080
+// Return "", if sa is not *syscall.SockaddrInet4 or *syscall.SockaddrInet6
+func SockaddrString(sa syscall.Sockaddr) string {
 	switch sa := sa.(type) {
 	case *syscall.SockaddrInet4:
-		copy([]byte(ip), sa.Addr[0:])
-		port = sa.Port
+		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.FormatInt(int64(sa.Port), 10))
 	case *syscall.SockaddrInet6:
-		copy([]byte(ip), sa.Addr[0:])
-		port = sa.Port
-	default:
-		return ""
+		host := net.IP(sa.Addr[:]).String()
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				host += "%" + ifi.Name
+			} else {
+				host += "%" + strconv.FormatUint(uint64(sa.ZoneId), 10)
+			}
+		}
+		return net.JoinHostPort(host, strconv.FormatInt(int64(sa.Port), 10))
 	}
-	return ip.String() + ":" + strconv.FormatInt(int64(port), 10)
+	return ""
 }
 
 // SetSendBuffSize set SO_SNDBUF
@@ -1,7 +1,10 @@
 package goev
 
 import (
+	"crypto/tls"
+	"os"
 	"sync"
+	"syscall"
 )
 
 // global option
@@ -22,23 +25,60 @@ type options struct {
 	// acceptor options
 	reuseAddr     bool // SO_REUSEADDR
 	reusePort     bool // SO_REUSEPORT
+	ipv6Only      bool // IPV6_V6ONLY
 	listenBacklog int  //
+	tlsConfig     *tls.Config
+	tlsTimeout    int64
+
+	acceptFilter        func(fd int, sa syscall.Sockaddr) bool
+	acceptMaxConns      int
+	acceptMaxConnsPerIP int
+	acceptIPv4Prefix    int
+	acceptIPv6Prefix    int
+	acceptRatePerSec    int
+	acceptRateBurst     int
+	proxyProtoTimeout   int64
+	proxyProtoPending   int
+
+	acceptReserveFd      bool
+	acceptRejectPayload  []byte
+	acceptEMFILECallback func()
+
+	tcpFastOpenQlen   int // TCP_FASTOPEN
+	tcpDeferAccept    int // TCP_DEFER_ACCEPT
+	incomingCPU       int // SO_INCOMING_CPU, ignore < 0
+	reusePortCPUGroup int // SO_ATTACH_REUSEPORT_CBPF
+	tcpNoDelay        bool
+	tcpKeepAliveIdle  int
+	tcpKeepAliveIntvl int
+	tcpKeepAliveCnt   int
+
+	unixSocketMode os.FileMode
+	unixSocketUID  int
+	unixSocketGID  int
 
 	// connector options
 
+	// udp options
+	dgramRecvBatch int
+
 	// acceptor and connector options
 	sockRcvBufSize int // ignore equal 0
+	sockSndBufSize int // ignore equal 0
 
 	// reactor options
-	evPollLockOSThread  bool
-	evPollNum           int //
-	evFdMaxSize         int
-	evPollReadBuffSize  int
-	evPollWriteBuffSize int
-	//evPollCacheTimePeriod int
+	evPollLockOSThread    bool
+	evPollNum             int //
+	evFdMaxSize           int
+	evPollReadBuffSize    int
+	evPollWriteBuffSize   int
+	dispatcher            Dispatcher
+	evPollCacheTimePeriod int
+	clock                 Clock
 
 	// timer
 	timerHeapInitSize int //
+	timerBackend      int
 }
 
 // Option function
@@ -47,16 +87,21 @@ type Option func(*options)
 func setOptions(optL ...Option) options {
 	//= defaut options
 	opts := options{
-		reuseAddr:         true,
-		reusePort:         false,
-		evPollNum:         1,
-		evFdMaxSize:       8192,
-		listenBacklog:     512, // go default 128
-		timerHeapInitSize: 1024,
-		//evPollCacheTimePeriod: 250,
+		reuseAddr:           true,
+		reusePort:           false,
+		evPollNum:           1,
+		evFdMaxSize:         8192,
+		listenBacklog:       512, // go default 128
+		incomingCPU:         -1,
+		unixSocketUID:       -1,
+		unixSocketGID:       -1,
+		dgramRecvBatch:      1,
+		proxyProtoPending:   1024,
+		timerHeapInitSize:   1024,
 		evPollLockOSThread:  false,
 		evPollReadBuffSize:  8192,
 		evPollWriteBuffSize: 16 * 1024,
+		dispatcher:          DispatchByFd,
 	}
 
 	for _, opt := range optL {
@@ -83,6 +128,226 @@ func ReusePort(v bool) Option {
 	}
 }
 
+// IPv6Only for IPV6_V6ONLY, only works for IPv6 listener (e.g. [::]:8080)
+//
+// The default is false, which means a dual-stack listener that accepts both IPv4 and IPv6 connections
+func IPv6Only(v bool) Option {
+	return func(o *options) {
+		o.ipv6Only = v
+	}
+}
+
+// TLSConfig enables TLS termination for the connections accepted by Acceptor,
+// the EvHandler created by newEvHanlderFunc must embed TLSHandle (checked by NewAcceptor,
+// which calls newEvHanlderFunc once more)
+func TLSConfig(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = cfg
+	}
+}
+
+// TLSHandshakeTimeout the connection accepted by Acceptor is closed if the TLS handshake isn't
+// completed in `timeout' milliseconds (default 10s), it bounds the lifetime of the handshake goroutine
+func TLSHandshakeTimeout(timeout int64) Option {
+	if timeout < 1 {
+		panic("goev:TLSHandshakeTimeout param is illegal")
+	}
+	return func(o *options) {
+		o.tlsTimeout = timeout
+	}
+}
+
+// AcceptFilter is called for every new connection of Acceptor before newEvHanlderFunc,
+// sa is the peer address (nil for unix socket). The connection is closed if it returns false.
+// It can also be used to set socket options on fd
+func AcceptFilter(f func(fd int, sa syscall.Sockaddr) bool) Option {
+	return func(o *options) {
+		o.acceptFilter = f
+	}
+}
+
+// AcceptMaxConns limits the total number of the connections of Acceptor, the new connection is
+// closed when it's reached. The counter is decremented by IOHandle.Destroy of the handler
+func AcceptMaxConns(n int) Option {
+	if n < 1 {
+		panic("goev:AcceptMaxConns param is illegal")
+	}
+	return func(o *options) {
+		o.acceptMaxConns = n
+	}
+}
+
+// AcceptMaxConnsPerIP limits the number of the connections of Acceptor from the same source,
+// the source is the peer IP masked by ipv4PrefixLen/ipv6PrefixLen (e.g. 32/128 per IP, 24/64 per CIDR).
+// The counter is decremented by IOHandle.Destroy of the handler
+func AcceptMaxConnsPerIP(n, ipv4PrefixLen, ipv6PrefixLen int) Option {
+	if n < 1 || ipv4PrefixLen < 0 || ipv4PrefixLen > 32 || ipv6PrefixLen < 0 || ipv6PrefixLen > 128 {
+		panic("goev:AcceptMaxConnsPerIP param is illegal")
+	}
+	return func(o *options) {
+		o.acceptMaxConnsPerIP = n
+		o.acceptIPv4Prefix = ipv4PrefixLen
+		o.acceptIPv6Prefix = ipv6PrefixLen
+	}
+}
+
+// AcceptRateLimit limits the accept rate of Acceptor by a token bucket, `ratePerSec' tokens are
+// added per second and the bucket holds at most `burst' tokens. The new connection is closed
+// if there is no token, the connections rejected by the other limits don't take tokens
+func AcceptRateLimit(ratePerSec, burst int) Option {
+	if ratePerSec < 1 || burst < 1 {
+		panic("goev:AcceptRateLimit param is illegal")
+	}
+	return func(o *options) {
+		o.acceptRatePerSec = ratePerSec
+		o.acceptRateBurst = burst
+	}
+}
+
+// ProxyProtocol enables the PROXY protocol v1/v2 for the connections accepted by Acceptor
+// (behind HAProxy, AWS NLB etc.), the header is received before newEvHanlderFunc, and
+// the connection is closed if it isn't received in `timeout' milliseconds.
+// The header can be retrieved by IOHandle.ProxyHeader in OnOpen, AcceptFilter and
+// AcceptMaxConnsPerIP use the real client address
+func ProxyProtocol(timeout int64) Option {
+	if timeout < 1 {
+		panic("goev:ProxyProtocol param is illegal")
+	}
+	return func(o *options) {
+		o.proxyProtoTimeout = timeout
+	}
+}
+
+// ProxyProtocolMaxPending limits the number of the connections waiting for the PROXY protocol
+// header (default 1024), the new connection is closed at once if it's exceeded.
+// They aren't counted by AcceptMaxConns and AcceptMaxConnsPerIP, which need the real address
+func ProxyProtocolMaxPending(n int) Option {
+	if n < 1 {
+		panic("goev:ProxyProtocolMaxPending param is illegal")
+	}
+	return func(o *options) {
+		o.proxyProtoPending = n
+	}
+}
+
+// AcceptReserveFd keeps a spare fd (/dev/null) in Acceptor. When accept fails with EMFILE/ENFILE
+// (too many open files), the spare fd is closed to accept the pending connection, which is closed
+// at once after `rejectPayload' (can be nil, e.g. "HTTP/1.1 503 Service Unavailable\r\n\r\n")
+// is sent, then the spare fd is reopened. Otherwise the listener is removed and retried 100ms later,
+// and the clients hang in the backlog
+func AcceptReserveFd(rejectPayload []byte) Option {
+	return func(o *options) {
+		o.acceptReserveFd = true
+		o.acceptRejectPayload = rejectPayload
+	}
+}
+
+// AcceptEMFILECallback is called within the evpoll coroutine every time accept fails with
+// EMFILE/ENFILE, so operators can notice the descriptor exhaustion (keep it cheap, e.g. a metric).
+// Refer to Acceptor.EMFILECount
+func AcceptEMFILECallback(fn func()) Option {
+	return func(o *options) {
+		o.acceptEMFILECallback = fn
+	}
+}
+
+// TCPFastOpen enables TCP Fast Open of the Acceptor listener (TCP_FASTOPEN), `qlen' is the max
+// number of the pending TFO requests. The server side must be enabled by `sysctl net.ipv4.tcp_fastopen'
+// (bit 0x2). The data in the SYN is readable as soon as the connection is accepted.
+// Refer to Connector.ConnectData for the client side
+func TCPFastOpen(qlen int) Option {
+	if qlen < 1 {
+		panic("goev:TCPFastOpen param is illegal")
+	}
+	return func(o *options) {
+		o.tcpFastOpenQlen = qlen
+	}
+}
+
+// TCPDeferAccept for TCP_DEFER_ACCEPT of the Acceptor listener, the connection is not accepted
+// until the data arrives (the handshake is completed by the kernel), or `seconds' has passed
+func TCPDeferAccept(seconds int) Option {
+	if seconds < 1 {
+		panic("goev:TCPDeferAccept param is illegal")
+	}
+	return func(o *options) {
+		o.tcpDeferAccept = seconds
+	}
+}
+
+// IncomingCPU for SO_INCOMING_CPU of the Acceptor listener (kernel >= 4.4), the kernel prefers
+// the listener of the SO_REUSEPORT group whose `cpu' is the CPU handling the packet.
+// Refer to ReusePortSteerByCPU
+func IncomingCPU(cpu int) Option {
+	if cpu < 0 {
+		panic("goev:IncomingCPU param is illegal")
+	}
+	return func(o *options) {
+		o.incomingCPU = cpu
+	}
+}
+
+// ReusePortSteerByCPU attaches a BPF program (SO_ATTACH_REUSEPORT_CBPF, kernel >= 4.5) to the
+// SO_REUSEPORT group of the Acceptor listener, the new connection is dispatched to the listener
+// `the CPU handling the packet % groupSize', which is the order the acceptors were created.
+//
+// Create `groupSize' acceptors with ReusePort(true) and ReusePortSteerByCPU(groupSize), each one
+// bound to a reactor whose evPoll runs on the CPU, so the connection is handled on the CPU
+// receiving its packets (cache locality). Refer to DispatchIncomingCPU within one reactor
+func ReusePortSteerByCPU(groupSize int) Option {
+	if groupSize < 1 {
+		panic("goev:ReusePortSteerByCPU param is illegal")
+	}
+	return func(o *options) {
+		o.reusePortCPUGroup = groupSize
+	}
+}
+
+// TCPNoDelay for TCP_NODELAY, set on every connection accepted by Acceptor (the kernel default is off)
+func TCPNoDelay(v bool) Option {
+	return func(o *options) {
+		o.tcpNoDelay = v
+	}
+}
+
+// TCPKeepAlive enables SO_KEEPALIVE on every connection accepted by Acceptor, the probes are sent
+// after the connection is idle for `idle' seconds, every `interval' seconds, and the connection
+// is closed after `count' unanswered probes (TCP_KEEPIDLE, TCP_KEEPINTVL, TCP_KEEPCNT)
+func TCPKeepAlive(idle, interval, count int) Option {
+	if idle < 1 || interval < 1 || count < 1 {
+		panic("goev:TCPKeepAlive param is illegal")
+	}
+	return func(o *options) {
+		o.tcpKeepAliveIdle = idle
+		o.tcpKeepAliveIntvl = interval
+		o.tcpKeepAliveCnt = count
+	}
+}
+
+// UnixSocketMode sets the permission bits of the socket file of the unix:/unixpacket: Acceptor
+// (e.g. 0660), the clients need the write permission to connect. It's set before listen.
+// The abstract socket (unix:@name) has no file, use IOHandle.PeerCred to authorize the peer
+func UnixSocketMode(mode os.FileMode) Option {
+	if mode == 0 || mode&^os.ModePerm != 0 {
+		panic("goev:UnixSocketMode param is illegal")
+	}
+	return func(o *options) {
+		o.unixSocketMode = mode
+	}
+}
+
+// UnixSocketOwner sets the owner of the socket file of the unix:/unixpacket: Acceptor, -1 means
+// not changed (changing uid requires privilege). It's set before listen
+func UnixSocketOwner(uid, gid int) Option {
+	if uid < -1 || gid < -1 {
+		panic("goev:UnixSocketOwner param is illegal")
+	}
+	return func(o *options) {
+		o.unixSocketUID = uid
+		o.unixSocketGID = gid
+	}
+}
+
 // ListenBacklog For syscall.listen(fd, backlog), also affect `for i < backlog/2 { syscall.accept() }`
 func ListenBacklog(v int) Option {
 	return func(o *options) {
@@ -97,6 +362,26 @@ func SockRcvBufSize(n int) Option {
 	}
 }
 
+// SockSndBufSize for SO_SNDBUF, for new sockfd in acceptor/connector (the accepted
+// connections inherit it from the listener)
+func SockSndBufSize(n int) Option {
+	return func(o *options) {
+		o.sockSndBufSize = n
+	}
+}
+
+// DgramRecvBatch is the max number of datagrams received by one recvmmsg syscall in NewUDPListener
+// (default 1). evPollReadBuff is divided into n slots equally, so the max datagram size is
+// EvPollReadBuffSize/n, enlarge EvPollReadBuffSize accordingly (e.g. 64KB for n = 8 and 8KB datagrams)
+func DgramRecvBatch(n int) Option {
+	if n < 1 {
+		panic("goev:DgramRecvBatch param is illegal")
+	}
+	return func(o *options) {
+		o.dgramRecvBatch = n
+	}
+}
+
 // EvFdMaxSize for ArrayMapUnion数据结构中array的容量, 性能不会线性增长,
 // 主要根据自己的服务中fd并发数量(fd=0~n的范围)来定
 // fd数量超过此值并不会拒绝服务, 只是存储结构切换到map
@@ -135,6 +420,20 @@ func EvPollNum(n int) Option {
 	}
 }
 
+// EvPollDispatcher selects the evPoll for each fd registered by Reactor.AddEvHandler,
+// such as DispatchRoundRobin(), DispatchLeastConn, DispatchRemoteAddrHash or a user function.
+//
+// The default is DispatchByFd (fd % evPollNum), which may be skewed when long-lived
+// connections cluster on certain fd values
+func EvPollDispatcher(d Dispatcher) Option {
+	if d == nil {
+		panic("goev:EvPollDispatcher param is illegal")
+	}
+	return func(o *options) {
+		o.dispatcher = d
+	}
+}
+
 // EvReadyNum evPolling for a quantity of n Ready I/O events at once is beneficial for improving
 // batch processing capability. However, if the quantity is too large,
 // it can easily impact the processing of new events.
@@ -174,15 +473,39 @@ func EvPollWriteBuffSize(n int) Option {
 	}
 }
 
-// EvPollCacheTimePeriod refer to whether caching timestamps within the 'evpoll' range is
-// enabled (updated every `period' milliseconds)
-//
-// Turn off cache time if `period' equal zero
-//func EvPollCacheTimePeriod(period int) Option {
-//	return func(o *options) {
-//		o.evPollCacheTimePeriod = period
-//	}
-//}
+// EvPollCacheTimePeriod the cached time within the 'evpoll' range (IOHandle.Now) is refreshed
+// after every epoll_wait returns, it's also refreshed by a timer every `period' milliseconds
+// if `period' > 0 (default 0)
+func EvPollCacheTimePeriod(period int) Option {
+	if period < 0 {
+		panic("goev:EvPollCacheTimePeriod param is illegal")
+	}
+	return func(o *options) {
+		o.evPollCacheTimePeriod = period
+	}
+}
+
+// EvPollClock sets the time source of evPoll (the cached time and the timers), the default
+// is the system clock
+func EvPollClock(c Clock) Option {
+	if c == nil {
+		panic("goev:EvPollClock param is illegal")
+	}
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// TimerBackendType selects the timer implementation of evPoll, TimerHeap(default) or TimerWheel.
+// They have the same contract (OnTimeout, ScheduleTimerFunc, CancelTimerID, ResetTimer etc.)
+func TimerBackendType(v int) Option {
+	if v != TimerHeap && v != TimerWheel {
+		panic("goev:TimerBackendType param is illegal")
+	}
+	return func(o *options) {
+		o.timerBackend = v
+	}
+}
 
 // TimerHeapInitSize is the initial array size of the heap structure used to implement timers
 func TimerHeapInitSize(n int) Option {
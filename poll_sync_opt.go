@@ -17,6 +17,9 @@ const (
 	PollSyncCache int = 1
 )
 
+// pollSyncShutdown notify evPoll to shutdown, arg is the deadline in unix millisecond
+const pollSyncShutdown int = -1
+
 // PollSyncCacheOpt sync arg
 type PollSyncCacheOpt struct {
 	ID    int
@@ -69,17 +72,23 @@ func (c *pollSyncOpt) init(typ int, val any) {
 	})
 }
 func (c *pollSyncOpt) doSync(op pollSyncOptArg) {
-	if op.typ == PollSyncCache {
+	switch op.typ {
+	case PollSyncCache:
 		c.evPoll.pCacheSet(op.arg.(PollSyncCacheOpt).ID, op.arg.(PollSyncCacheOpt).Value)
+	case pollSyncShutdown:
+		c.evPoll.startShutdown(op.arg.(int64))
 	}
 }
 func (c *pollSyncOpt) push(typ int, val any) {
 	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.efd < 0 { // evPoll closed
+		return
+	}
 	c.writeq.PushBack(pollSyncOptArg{
 		typ: typ,
 		arg: val,
 	})
-	c.mtx.Unlock()
 
 	if !c.notified.CompareAndSwap(0, 1) {
 		return
@@ -130,3 +139,11 @@ func (c *pollSyncOpt) OnRead() bool {
 	}
 	return true
 }
+
+// close called when evPoll is closed
+func (c *pollSyncOpt) close() {
+	c.mtx.Lock()
+	syscall.Close(c.efd)
+	c.efd = -1
+	c.mtx.Unlock()
+}
@@ -0,0 +1,331 @@
+package goev
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// PROXY protocol, refer to https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+const (
+	proxyV1MaxLen   = 107
+	proxyV2HdrLen   = 16
+	proxyCmdLocal   = 0x0
+	proxyCmdProxy   = 0x1
+	proxyFamUnspec  = 0x0
+	proxyFamInet    = 0x1
+	proxyFamInet6   = 0x2
+	proxyFamUnix    = 0x3
+	proxyV2InetLen  = 12
+	proxyV2Inet6Len = 36
+	proxyV2UnixLen  = 216
+)
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ProxyHeader is the PROXY protocol header sent by the load balancer (e.g. HAProxy, AWS NLB)
+// before the connection data, enabled by options.ProxyProtocol
+type ProxyHeader struct {
+	// Version is 1 (text) or 2 (binary)
+	Version int
+
+	// Local is true for v2 LOCAL command or v1 UNKNOWN (e.g. health checks of the proxy),
+	// SrcAddr/DstAddr are nil, the connection endpoints are the real addresses
+	Local bool
+
+	// SrcAddr is the real client address, DstAddr is the address the client connected to.
+	// *syscall.SockaddrInet4, *syscall.SockaddrInet6 or *syscall.SockaddrUnix (v2 only)
+	SrcAddr syscall.Sockaddr
+	DstAddr syscall.Sockaddr
+
+	// TLVs are the v2 type-length-value extensions (e.g. ALPN, authority, AWS VPC endpoint id)
+	TLVs []ProxyTLV
+}
+
+// ProxyTLV is a v2 TLV of ProxyHeader
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// proxyProtoConn receives the PROXY protocol header of an accepted connection within the
+// acceptor evpoll, then hands the fd to Acceptor.accepted
+//
+// It reads exactly the header, the connection data is left in the socket for the handler
+type proxyProtoConn struct {
+	IOHandle
+
+	a   *Acceptor
+	sa  syscall.Sockaddr
+	hdr []byte
+}
+
+func (a *Acceptor) proxyProtoAccept(fd int, sa syscall.Sockaddr) {
+	if a.proxyPending >= a.proxyMaxPending {
+		syscall.Close(fd)
+		return
+	}
+	pc := &proxyProtoConn{a: a, sa: sa}
+	ep := a.getEvPoll()
+	if err := ep.add(fd, EvIn, pc); err != nil {
+		syscall.Close(fd)
+		return
+	}
+	a.proxyPending++
+	if _, err := pc.ScheduleTimerFunc(a.proxyTimeout, 0, func(int64) bool {
+		pc.fail()
+		return false
+	}); err != nil {
+		pc.fail()
+	}
+}
+
+func (pc *proxyProtoConn) OnRead() bool {
+	fd := pc.Fd()
+	if fd < 1 {
+		return false
+	}
+	buf := pc.ep.evPollReadBuff
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, syscall.MSG_PEEK)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.EAGAIN {
+			return true
+		} else if n == 0 || err != nil {
+			pc.fail()
+			return true
+		}
+		want, err := pc.want(buf[:n])
+		if err != nil {
+			pc.fail()
+			return true
+		}
+		m, err := syscall.Read(fd, buf[:want])
+		if err == syscall.EINTR || err == syscall.EAGAIN {
+			continue
+		} else if m < 1 {
+			pc.fail()
+			return true
+		}
+		pc.hdr = append(pc.hdr, buf[:m]...)
+		if ph, done, err := parseProxyHeader(pc.hdr); err != nil {
+			pc.fail()
+			return true
+		} else if done {
+			pc.done(ph)
+			return true
+		}
+	}
+}
+
+// want returns the number of the peeked bytes that belong to the header
+func (pc *proxyProtoConn) want(peek []byte) (int, error) {
+	sig := proxyV2Sig
+	if (len(pc.hdr) > 0 && pc.hdr[0] == 'P') || (len(pc.hdr) == 0 && peek[0] == 'P') {
+		sig = proxyV1Sig
+	}
+	// Check the signature as early as possible
+	for i := len(pc.hdr); i < len(sig) && i-len(pc.hdr) < len(peek); i++ {
+		if peek[i-len(pc.hdr)] != sig[i] {
+			return 0, errors.New("invalid signature")
+		}
+	}
+	if sig[0] == 'P' {
+		if i := bytes.IndexByte(peek, '\n'); i >= 0 {
+			if len(pc.hdr)+i+1 > proxyV1MaxLen {
+				return 0, errors.New("v1 header too long")
+			}
+			return i + 1, nil
+		}
+		if len(pc.hdr)+len(peek) >= proxyV1MaxLen {
+			return 0, errors.New("v1 header too long")
+		}
+		return len(peek), nil
+	}
+	need := proxyV2HdrLen
+	if len(pc.hdr) >= proxyV2HdrLen {
+		need += int(binary.BigEndian.Uint16(pc.hdr[14:16]))
+	}
+	if want := need - len(pc.hdr); want < len(peek) {
+		return want, nil
+	}
+	return len(peek), nil
+}
+
+func (pc *proxyProtoConn) detach() int {
+	fd := pc.Fd()
+	pc.CancelTimer(pc)
+	if fd > 0 {
+		pc.ep.remove(fd, EvAll)
+		pc.setFd(-1)
+		pc.a.proxyPending--
+	}
+	return fd
+}
+
+func (pc *proxyProtoConn) fail() {
+	if fd := pc.detach(); fd > 0 {
+		syscall.Close(fd)
+	}
+}
+
+func (pc *proxyProtoConn) done(ph *ProxyHeader) {
+	fd := pc.detach()
+	if fd < 1 {
+		return
+	}
+	sa := pc.sa
+	if ph.SrcAddr != nil {
+		sa = ph.SrcAddr
+	}
+	pc.a.accepted(fd, sa, ph)
+}
+
+func (pc *proxyProtoConn) OnClose() {
+	pc.fail()
+}
+
+// parseProxyHeader returns done = false if the header is incomplete
+func parseProxyHeader(hdr []byte) (ph *ProxyHeader, done bool, err error) {
+	if hdr[0] == 'P' {
+		if hdr[len(hdr)-1] != '\n' {
+			return nil, false, nil
+		}
+		ph, err = parseProxyV1(hdr)
+		return ph, err == nil, err
+	}
+	if len(hdr) < proxyV2HdrLen || len(hdr) < proxyV2HdrLen+int(binary.BigEndian.Uint16(hdr[14:16])) {
+		return nil, false, nil
+	}
+	ph, err = parseProxyV2(hdr)
+	return ph, err == nil, err
+}
+
+// PROXY TCP4 255.255.255.255 255.255.255.255 65535 65535\r\n
+func parseProxyV1(hdr []byte) (*ProxyHeader, error) {
+	line := string(hdr)
+	if !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("v1 header must end with CRLF")
+	}
+	fields := strings.Split(line[:len(line)-2], " ")
+	ph := &ProxyHeader{Version: 1}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		ph.Local = true
+		return ph, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("invalid v1 header")
+	}
+	var err error
+	if ph.SrcAddr, err = parseProxyV1Addr(fields[1], fields[2], fields[4]); err != nil {
+		return nil, err
+	}
+	if ph.DstAddr, err = parseProxyV1Addr(fields[1], fields[3], fields[5]); err != nil {
+		return nil, err
+	}
+	return ph, nil
+}
+
+func parseProxyV1Addr(proto, ip, port string) (syscall.Sockaddr, error) {
+	addr := net.ParseIP(ip)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if addr == nil || err != nil {
+		return nil, errors.New("invalid v1 address")
+	}
+	if proto == "TCP4" {
+		sa := &syscall.SockaddrInet4{Port: int(p)}
+		if addr = addr.To4(); addr == nil {
+			return nil, errors.New("invalid v1 address")
+		}
+		copy(sa.Addr[:], addr)
+		return sa, nil
+	}
+	if strings.IndexByte(ip, ':') < 0 { // TCP6 requires an IPv6 literal
+		return nil, errors.New("invalid v1 address")
+	}
+	sa := &syscall.SockaddrInet6{Port: int(p)}
+	copy(sa.Addr[:], addr.To16())
+	return sa, nil
+}
+
+func parseProxyV2(hdr []byte) (*ProxyHeader, error) {
+	if !bytes.Equal(hdr[:12], proxyV2Sig) || hdr[12]>>4 != 2 {
+		return nil, errors.New("invalid v2 header")
+	}
+	ph := &ProxyHeader{Version: 2}
+	body := hdr[proxyV2HdrLen:]
+	switch hdr[12] & 0x0f {
+	case proxyCmdLocal:
+		ph.Local = true
+		return ph, nil // The address block and TLVs must be ignored
+	case proxyCmdProxy:
+	default:
+		return nil, errors.New("invalid v2 command")
+	}
+
+	var addrLen int
+	switch hdr[13] >> 4 {
+	case proxyFamUnspec:
+		ph.Local = true
+		return ph, nil
+	case proxyFamInet:
+		addrLen = proxyV2InetLen
+		if len(body) < addrLen {
+			return nil, errors.New("v2 address too short")
+		}
+		src, dst := &syscall.SockaddrInet4{}, &syscall.SockaddrInet4{}
+		copy(src.Addr[:], body[0:4])
+		copy(dst.Addr[:], body[4:8])
+		src.Port = int(binary.BigEndian.Uint16(body[8:10]))
+		dst.Port = int(binary.BigEndian.Uint16(body[10:12]))
+		ph.SrcAddr, ph.DstAddr = src, dst
+	case proxyFamInet6:
+		addrLen = proxyV2Inet6Len
+		if len(body) < addrLen {
+			return nil, errors.New("v2 address too short")
+		}
+		src, dst := &syscall.SockaddrInet6{}, &syscall.SockaddrInet6{}
+		copy(src.Addr[:], body[0:16])
+		copy(dst.Addr[:], body[16:32])
+		src.Port = int(binary.BigEndian.Uint16(body[32:34]))
+		dst.Port = int(binary.BigEndian.Uint16(body[34:36]))
+		ph.SrcAddr, ph.DstAddr = src, dst
+	case proxyFamUnix:
+		addrLen = proxyV2UnixLen
+		if len(body) < addrLen {
+			return nil, errors.New("v2 address too short")
+		}
+		ph.SrcAddr = &syscall.SockaddrUnix{Name: cString(body[0:108])}
+		ph.DstAddr = &syscall.SockaddrUnix{Name: cString(body[108:216])}
+	default:
+		return nil, errors.New("invalid v2 address family")
+	}
+
+	for tlvs := body[addrLen:]; len(tlvs) > 0; {
+		if len(tlvs) < 3 {
+			return nil, errors.New("invalid v2 TLV")
+		}
+		l := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+l {
+			return nil, errors.New("invalid v2 TLV")
+		}
+		ph.TLVs = append(ph.TLVs, ProxyTLV{Type: tlvs[0], Value: append([]byte(nil), tlvs[3:3+l]...)})
+		tlvs = tlvs[3+l:]
+	}
+	return ph, nil
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
@@ -3,11 +3,15 @@ package goev
 // Autor cuisw. 2023.07
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Reactor provides an I/O event-driven event handling model, where multiple epoll processes
@@ -19,6 +23,11 @@ type Reactor struct {
 	evPollLockOSThread bool
 	evPollNum          int
 	evPolls            []evPoll
+
+	dispatcher Dispatcher
+	fdPolls    *fdPollMap
+
+	timerPollSeq atomic.Uint32 // Round-robin for AfterFunc
 }
 
 // NewReactor return an instance
@@ -31,30 +40,47 @@ func NewReactor(opts ...Option) (*Reactor, error) {
 		evPollLockOSThread: evOptions.evPollLockOSThread,
 		evPollNum:          evOptions.evPollNum,
 		evPolls:            make([]evPoll, evOptions.evPollNum),
+		dispatcher:         evOptions.dispatcher,
+	}
+	if r.evPollNum > 1 {
+		r.fdPolls = newFdPollMap(evOptions.evFdMaxSize)
 	}
 	for i := 0; i < r.evPollNum; i++ {
 		r.evPolls[i].id = i
-		timer := newTimer4Heap(evOptions.timerHeapInitSize)
-		if err := r.evPolls[i].open(evOptions.evFdMaxSize, timer,
+		clock := newEvClock(evOptions.clock)
+		var timer evTimer
+		if evOptions.timerBackend == TimerWheel {
+			timer = newTimerWheel(evOptions.timerHeapInitSize, clock)
+		} else {
+			timer = newTimer4Heap(evOptions.timerHeapInitSize, clock)
+		}
+		if err := r.evPolls[i].open(evOptions.evFdMaxSize, timer, clock,
 			evOptions.evPollReadBuffSize, evOptions.evPollWriteBuffSize); err != nil {
 			return nil, err
 		}
 		r.evPolls[i].add(timer.timerfd(), EvIn, timer)
+		if period := int64(evOptions.evPollCacheTimePeriod); period > 0 {
+			// The cached time is refreshed by timer.OnRead
+			r.evPolls[i].scheduleTimer(period, period, func(int64) bool { return true })
+		}
 	}
 	return r, nil
 }
 
 // AddEvHandler can register a file descriptor (fd) and its corresponding handler object into the Reactor.
-// If multiple evPool instances are specified internally, the fd will be rotated to the designated
-// evPool instance based on fd % idx.
+// If multiple evPool instances are specified internally, the fd will be dispatched to the evPool
+// instance selected by options.EvPollDispatcher (default fd % evPollNum).
 func (r *Reactor) AddEvHandler(eh EvHandler, fd int, events uint32) error {
 	if fd < 1 || eh == nil { // NOTE fd must > 0
 		return errors.New("AddEvHandler: invalid params")
 	}
 	i := 0
 	if r.evPollNum > 1 {
-		// fd is a self-incrementing and cyclic integer, can be allocated through round-robin distribution.
-		i = fd % r.evPollNum
+		i = r.dispatcher(r, fd)
+		if i < 0 || i >= r.evPollNum {
+			i = DispatchByFd(r, fd)
+		}
+		r.fdPolls.store(fd, i)
 	}
 	eh.setReactor(r) // MUST before add
 	return r.evPolls[i].add(fd, events, eh)
@@ -68,7 +94,7 @@ func (r *Reactor) AppendEvent(fd int, events uint32) error {
 	}
 	i := 0
 	if r.evPollNum > 1 {
-		i = fd % r.evPollNum
+		i = r.fdPolls.load(fd)
 	}
 	return r.evPolls[i].append(fd, events)
 }
@@ -82,11 +108,24 @@ func (r *Reactor) RemoveEvent(fd int, events uint32) error {
 	}
 	i := 0
 	if r.evPollNum > 1 {
-		i = fd % r.evPollNum
+		i = r.fdPolls.load(fd)
 	}
 	return r.evPolls[i].remove(fd, events)
 }
 
+// EvPollNum returns the number of evPoll instances
+func (r *Reactor) EvPollNum() int {
+	return r.evPollNum
+}
+
+// EvPollLoad returns the number of EvHandlers registered with the evPoll of the specified index
+func (r *Reactor) EvPollLoad(pollIndex int) int {
+	if pollIndex < 0 || pollIndex >= r.evPollNum {
+		return 0
+	}
+	return int(r.evPolls[pollIndex].handlerNum.Load())
+}
+
 // InitPollSyncOpt called before Reactor.Run
 func (r *Reactor) InitPollSyncOpt(typ int, val any) {
 	for i := 0; i < r.evPollNum; i++ {
@@ -101,6 +140,103 @@ func (r *Reactor) PollSyncOpt(typ int, val any) {
 	}
 }
 
+// PostTo queues fn to the evPoll of the specified index, fn will be called within
+// the evPoll coroutine. pollIndex can be retrieved by IOHandle.EvPollIndex.
+// It returns net.ErrClosed if the reactor has been stopped, fn will never be called then
+//
+// It is safe for concurrent use by multiple goroutines
+func (r *Reactor) PostTo(pollIndex int, fn func()) error {
+	if pollIndex < 0 || pollIndex >= r.evPollNum || fn == nil {
+		return errors.New("PostTo: invalid params")
+	}
+	if !r.evPolls[pollIndex].push(asyncWriteItem{fn: fn}) {
+		return net.ErrClosed
+	}
+	return nil
+}
+
+// Timer is the handle of the timer scheduled by Reactor.ScheduleTimer/AfterFunc
+type Timer struct {
+	ep       *evPoll
+	id       TimerID // Only accessed within the evPoll coroutine
+	canceled atomic.Bool
+}
+
+// Cancel cancels the timer, it's ignored if the timer has expired (not periodic) or been canceled.
+//
+// It is safe for concurrent use by multiple goroutines
+func (t *Timer) Cancel() {
+	if t.canceled.Swap(true) {
+		return
+	}
+	// Queued after the scheduling, so the id has been set when it's called
+	t.ep.push(asyncWriteItem{fn: func() {
+		if t.id != 0 {
+			t.ep.cancelTimer(t.id)
+		}
+	}})
+}
+
+func (t *Timer) schedule(eh EvHandler, fn func(now int64) bool, delay, interval int64) {
+	if t.canceled.Load() {
+		return
+	}
+	t.id, _ = t.ep.timer.schedule(eh, fn, delay, interval)
+}
+
+// ScheduleTimer schedules a timer on the evPoll of the specified index, handler.OnTimeout is called
+// within the evPoll coroutine when it expires, so the handler doesn't need a fd (standalone timer).
+// If the handler has been registered with the reactor, pollIndex must be its EvPollIndex,
+// otherwise it's bound to the evPoll within the evPoll coroutine.
+// Returns net.ErrClosed if the reactor has been stopped.
+//
+// It is safe for concurrent use by multiple goroutines
+func (r *Reactor) ScheduleTimer(pollIndex int, handler EvHandler, delay, interval int64) (*Timer, error) {
+	if pollIndex < 0 || pollIndex >= r.evPollNum || handler == nil || delay < 0 || interval < 0 {
+		return nil, errors.New("ScheduleTimer: invalid params")
+	}
+	ep := &(r.evPolls[pollIndex])
+	if hep := handler.getEvPoll(); hep != nil && hep != ep {
+		return nil, errors.New("ScheduleTimer: handler is registered with another evPoll")
+	}
+	t := &Timer{ep: ep}
+	if !ep.push(asyncWriteItem{fn: func() {
+		// The handler may be shared, so it's initialized within the evPoll coroutine
+		if handler.getEvPoll() == nil {
+			handler.setReactor(r)
+			handler.setParams(-1, ep, handler)
+		} else if handler.getEvPoll() != ep {
+			t.canceled.Store(true)
+			return
+		}
+		t.schedule(handler, nil, delay, interval)
+	}}) {
+		return nil, net.ErrClosed
+	}
+	return t, nil
+}
+
+// AfterFunc calls fn after delay milliseconds within an evPoll coroutine (round-robin),
+// so fn must not block. Returns net.ErrClosed if the reactor has been stopped.
+//
+// It is safe for concurrent use by multiple goroutines
+func (r *Reactor) AfterFunc(delay int64, fn func()) (*Timer, error) {
+	if delay < 0 || fn == nil {
+		return nil, errors.New("AfterFunc: invalid params")
+	}
+	ep := &(r.evPolls[int(r.timerPollSeq.Add(1)%uint32(r.evPollNum))])
+	t := &Timer{ep: ep}
+	if !ep.push(asyncWriteItem{fn: func() {
+		t.schedule(nil, func(int64) bool {
+			fn()
+			return false
+		}, delay, 0)
+	}}) {
+		return nil, net.ErrClosed
+	}
+	return t, nil
+}
+
 // Run starts the multi-event evpolling to run.
 func (r *Reactor) Run() error {
 	var wg sync.WaitGroup
@@ -116,6 +252,9 @@ func (r *Reactor) Run() error {
 				runtime.LockOSThread()
 			}
 			err := r.evPolls[j].run(&wg)
+			if err == nil { // stopped
+				return
+			}
 			errSMtx.Lock()
 			errS = append(errS, fmt.Sprintf("evPoll#%d err: %s", j, err.Error()))
 			errSMtx.Unlock()
@@ -128,3 +267,42 @@ func (r *Reactor) Run() error {
 	}
 	return errors.New(strings.Join(errS, "; "))
 }
+
+// Stop stops all evPolls immediately. Acceptors are closed, the pending async data is flushed
+// once (unsent data is discarded), OnClose is called on every registered EvHandler, and then
+// all descriptors of evPolls are closed. Run will return nil after all evPolls have exited.
+//
+// It is safe for concurrent use by multiple goroutines
+func (r *Reactor) Stop() {
+	r.shutdown(time.Now().UnixMilli())
+}
+
+// Shutdown gracefully shuts down the reactor. Acceptors are closed at once, the EvHandler
+// which has no pending async data is closed (call OnClose), and the rest are closed after
+// their data has been flushed or the deadline of ctx has passed.
+//
+// Shutdown waits for all evPolls to exit (Run must be running). If ctx is done before that,
+// Stop is called and ctx.Err() is returned.
+func (r *Reactor) Shutdown(ctx context.Context) error {
+	var deadline int64 // 0 means waiting until all data is flushed
+	if t, ok := ctx.Deadline(); ok {
+		deadline = t.UnixMilli()
+	}
+	r.shutdown(deadline)
+
+	for i := 0; i < r.evPollNum; i++ {
+		select {
+		case <-r.evPolls[i].done:
+		case <-ctx.Done():
+			r.Stop()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (r *Reactor) shutdown(deadline int64) {
+	for i := 0; i < r.evPollNum; i++ {
+		r.evPolls[i].pollSyncOpt(pollSyncShutdown, deadline)
+	}
+}
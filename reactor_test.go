@@ -0,0 +1,207 @@
+package goev
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type shutdownConn struct {
+	IOHandle
+
+	r      *Reactor
+	closed chan struct{}
+}
+
+func (c *shutdownConn) OnOpen() bool {
+	if err := c.r.AddEvHandler(c, c.Fd(), EvIn); err != nil {
+		return false
+	}
+	return true
+}
+func (c *shutdownConn) OnRead() bool {
+	_, n, _ := c.Read()
+	return n > 0
+}
+func (c *shutdownConn) OnClose() {
+	c.Destroy(c)
+	close(c.closed)
+}
+
+func TestReactorShutdown(t *testing.T) {
+	r, err := NewReactor(EvPollNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	_, err = NewAcceptor(r, "127.0.0.1:8093", func() EvHandler {
+		return &shutdownConn{r: r, closed: closed}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- r.Run()
+	}()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8093")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(time.Millisecond * 100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = r.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-runErr; err != nil {
+		t.Fatalf("Run returned %s", err.Error())
+	}
+	select {
+	case <-closed:
+	default:
+		t.Fatal("OnClose not called")
+	}
+	if _, err = net.Dial("tcp", "127.0.0.1:8093"); err == nil {
+		t.Fatal("acceptor still listening")
+	}
+	if err = r.PostTo(0, func() {}); err != net.ErrClosed {
+		t.Fatalf("PostTo after Shutdown returned %v", err)
+	}
+	if tm, err := r.AfterFunc(10, func() {}); tm != nil || err != net.ErrClosed {
+		t.Fatalf("AfterFunc after Shutdown returned %v", err)
+	}
+	if tm, err := r.ScheduleTimer(0, &reactorTimer{}, 10, 0); tm != nil || err != net.ErrClosed {
+		t.Fatalf("ScheduleTimer after Shutdown returned %v", err)
+	}
+}
+
+func TestReactorPostTo(t *testing.T) {
+	r, err := NewReactor(EvPollNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	ch := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		j := i
+		if err = r.PostTo(j, func() { ch <- j }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("posted fn not called")
+		}
+	}
+	if r.PostTo(2, func() {}) == nil {
+		t.Fatal("PostTo accepts invalid index")
+	}
+}
+
+func TestReactorDispatcher(t *testing.T) {
+	r, err := NewReactor(EvPollNum(3), EvPollDispatcher(DispatchRoundRobin()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	var fds []int
+	for i := 0; i < 6; i++ {
+		fd, _ := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+		fds = append(fds, fd)
+		h := &fheapTimer{}
+		if err = r.AddEvHandler(h, fd, EvIn); err != nil {
+			t.Fatal(err)
+		}
+		if h.EvPollIndex() != i%3 {
+			t.Fatalf("fd %d dispatched to evPoll#%d", fd, h.EvPollIndex())
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if r.EvPollLoad(i) != 3+2 { // 3 internal handlers
+			t.Fatalf("evPoll#%d load %d", i, r.EvPollLoad(i))
+		}
+	}
+	for _, fd := range fds {
+		if err = r.RemoveEvent(fd, EvAll); err != nil {
+			t.Fatal(err)
+		}
+		unix.Close(fd)
+	}
+	if r.EvPollLoad(0) != 3 {
+		t.Fatalf("evPoll#0 load %d", r.EvPollLoad(0))
+	}
+}
+
+type reactorTimer struct {
+	IOHandle
+
+	n  int
+	ch chan int
+}
+
+func (h *reactorTimer) OnTimeout(now int64) bool {
+	h.n++
+	if h.n == 3 {
+		h.ch <- h.n
+		return false
+	}
+	return true
+}
+
+func TestReactorTimer(t *testing.T) {
+	r, err := NewReactor(EvPollNum(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	fired := make(chan int, 4)
+	if _, err = r.AfterFunc(10, func() { fired <- 1 }); err != nil {
+		t.Fatal(err)
+	}
+	tm, _ := r.AfterFunc(50, func() { fired <- 2 })
+	tm.Cancel()
+
+	h := &reactorTimer{ch: make(chan int, 1)}
+	if _, err = r.ScheduleTimer(1, h, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case v := <-fired:
+		if v != 1 {
+			t.Fatal("canceled timer fired")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AfterFunc not fired")
+	}
+	select {
+	case <-h.ch:
+	case <-time.After(time.Second):
+		t.Fatal("ScheduleTimer not fired")
+	}
+	// Bound within the evPoll coroutine
+	if h.EvPollIndex() != 1 {
+		t.Fatalf("standalone timer handler bound to evPoll#%d", h.EvPollIndex())
+	}
+	if _, err = r.ScheduleTimer(0, h, 10, 0); err == nil {
+		t.Fatal("ScheduleTimer accepts another evPoll")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(fired) != 0 {
+		t.Fatal("canceled timer fired")
+	}
+}
@@ -0,0 +1,134 @@
+package goev
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// maxSendFileSize limits the size of a single sendfile(2), don't block evPoll too long
+const maxSendFileSize int64 = 4 << 20
+
+// sendFileItem is a file segment waiting to be sent in asyncWriteBufQ
+type sendFileItem struct {
+	f      *os.File
+	offset int64
+	count  int64 // remaining bytes
+	sent   int64
+	done   func(sent int64, err error)
+}
+
+// send returns true if the segment has been sent completely or failed (err != nil),
+// the caller reports it by finish
+func (sf *sendFileItem) send(fd int) (bool, error) {
+	infd := int(sf.f.Fd())
+	for sf.count > 0 {
+		size := sf.count
+		if size > maxSendFileSize {
+			size = maxSendFileSize
+		}
+		n, err := syscall.Sendfile(fd, infd, &sf.offset, int(size)) // offset is updated
+		if n > 0 {
+			sf.count -= int64(n)
+			sf.sent += int64(n)
+		}
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			} else if err == syscall.EAGAIN {
+				return false, nil
+			}
+			return true, err
+		}
+		if n == 0 { // EOF, the file is shorter than expected
+			return true, errors.New("sendfile: unexpected EOF")
+		}
+	}
+	return true, nil
+}
+
+func (sf *sendFileItem) finish(err error) {
+	if sf.done != nil {
+		sf.done(sf.sent, err)
+	}
+}
+
+// SendFile sends count bytes of f starting at offset by sendfile(2) (zero-copy), the unsent part
+// is parked in the async write queue and resumed on EvOut (OnWrite must call AsyncOrderedFlush),
+// ordered with the data of Write/AsyncWrite.
+//
+// If sendfile(2) fails at once, the error is returned and done is not called. Otherwise done
+// (can be nil) is called later within the evpoll coroutine after the segment is sent completely,
+// failed, or discarded by Destroy (err = syscall.ECANCELED), never before SendFile returns.
+// f must not be closed before done is called.
+//
+// The unsent bytes are counted in WriteBufferedSize. If the segment fails after a part of it
+// has been sent, the stream to the peer is broken, so the rest of the queue is discarded
+// and the connection is closed (OnClose is called later within the evpoll coroutine).
+//
+// Can only be used within the poller goroutine
+func (h *IOHandle) SendFile(f *os.File, offset, count int64, done func(sent int64, err error)) error {
+	fd := h.Fd()
+	if fd < 1 { // NOTE fd must > 0
+		return syscall.EBADF
+	}
+	if f == nil || offset < 0 || count < 0 {
+		return errors.New("SendFile: invalid params")
+	}
+	sf := &sendFileItem{f: f, offset: offset, count: count, done: done}
+	if h.asyncWriteBufQ == nil || h.asyncWriteBufQ.IsEmpty() {
+		done, err := sf.send(fd)
+		if sf.sent > 0 {
+			h.touchWrite()
+		}
+		if err != nil {
+			if sf.sent > 0 {
+				h.abortWrite(h.eh)
+			}
+			return err
+		} else if done {
+			if sf.done != nil {
+				// done is never called before SendFile returns
+				if h.ep == nil || !h.ep.push(asyncWriteItem{fn: func() { sf.finish(nil) }}) {
+					sf.finish(nil)
+				}
+			}
+			return nil
+		}
+	}
+	if h.asyncWriteBufQ == nil {
+		h.asyncWriteBufQ = NewRingBuffer[asyncWriteBuf](2)
+	}
+	h.asyncWriteBufQ.PushBack(asyncWriteBuf{sf: sf})
+	h.asyncWriteBufSize += int(sf.count)
+	if h.asyncWriteWaiting == false {
+		h.asyncWriteWaiting = true
+		h.ep.append(fd, EvOut) // No need to use ET mode
+	}
+	h.onWriteBufGrow()
+	return nil
+}
+
+// abortWrite is called when a file segment failed in the middle of the stream, the data
+// behind it can't be sent, so the queue is discarded and the connection is closed
+func (h *IOHandle) abortWrite(eh EvHandler) {
+	fd := h.Fd()
+	// The data written before the connection is closed is not sent to the peer any more
+	syscall.Shutdown(fd, syscall.SHUT_WR)
+	if h.asyncWriteBufQ != nil {
+		for !h.asyncWriteBufQ.IsEmpty() {
+			abf, _ := h.asyncWriteBufQ.PopFront()
+			abf.discard(eh)
+		}
+	}
+	h.asyncWriteBufSize = 0
+	if h.ep == nil {
+		return
+	}
+	// Don't close it synchronously, we may be in OnRead/OnWrite
+	h.ep.push(asyncWriteItem{fn: func() {
+		if h.Fd() == fd {
+			h.closeInPoll()
+		}
+	}})
+}
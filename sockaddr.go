@@ -0,0 +1,72 @@
+package goev
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// resolveTCPAddr converts the addr to syscall.Sockaddr, and returns the socket domain of it.
+//
+// The addr format 192.168.0.1:8080, :8080, [::1]:8080 or [fe80::1%eth0]:8080
+// The empty host means 0.0.0.0 (use [::]:8080 for dual-stack)
+func resolveTCPAddr(addr string) (syscall.Sockaddr, int, error) {
+	return resolveAddr(addr, false)
+}
+
+// resolveListenAddr is the same as resolveTCPAddr, but port 0 is allowed (the kernel
+// chooses a port when binding)
+func resolveListenAddr(addr string) (syscall.Sockaddr, int, error) {
+	return resolveAddr(addr, true)
+}
+
+func resolveAddr(addr string, anyPort bool) (syscall.Sockaddr, int, error) {
+	host, portS, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, errors.New("address is invalid! 192.168.1.1:80 or [::1]:80 or :80")
+	}
+	port, err := strconv.ParseInt(portS, 10, 64)
+	if err != nil || port < 0 || port > 65535 || (port == 0 && !anyPort) {
+		return nil, 0, errors.New("port must in (0, 65536)")
+	}
+	if len(host) == 0 {
+		host = "0.0.0.0"
+	}
+	zone := ""
+	for i := len(host) - 1; i > 0; i-- {
+		if host[i] == '%' {
+			host, zone = host[:i], host[i+1:]
+			break
+		}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, errors.New("address is invalid! " + host + " is not an IP address")
+	}
+	if ip4 := ip.To4(); ip4 != nil && len(zone) == 0 {
+		sa := &syscall.SockaddrInet4{Port: int(port)}
+		copy(sa.Addr[:], ip4)
+		return sa, syscall.AF_INET, nil
+	}
+	sa := &syscall.SockaddrInet6{Port: int(port)}
+	copy(sa.Addr[:], ip.To16())
+	if len(zone) > 0 {
+		if sa.ZoneId, err = zoneToIndex(zone); err != nil {
+			return nil, 0, err
+		}
+	}
+	return sa, syscall.AF_INET6, nil
+}
+
+// zoneToIndex the zone may be the interface name or index
+func zoneToIndex(zone string) (uint32, error) {
+	if n, err := strconv.ParseUint(zone, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	ifi, err := net.InterfaceByName(zone)
+	if err != nil {
+		return 0, errors.New("address is invalid! zone " + zone + ": " + err.Error())
+	}
+	return uint32(ifi.Index), nil
+}
@@ -0,0 +1,103 @@
+package goev
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/shaovie/goev/netfd"
+)
+
+func TestResolveTCPAddr(t *testing.T) {
+	valid := []struct {
+		addr   string
+		domain int
+		port   int
+	}{
+		{":8080", syscall.AF_INET, 8080},
+		{"127.0.0.1:80", syscall.AF_INET, 80},
+		{"[::1]:8080", syscall.AF_INET6, 8080},
+		{"[::]:443", syscall.AF_INET6, 443},
+		{"[::ffff:10.0.0.1]:53", syscall.AF_INET, 53},
+		{"[fe80::1%1]:8080", syscall.AF_INET6, 8080},
+		{"[fe80::1%lo]:8080", syscall.AF_INET6, 8080},
+	}
+	for _, v := range valid {
+		sa, domain, err := resolveTCPAddr(v.addr)
+		if err != nil {
+			t.Fatalf("%s: %s", v.addr, err.Error())
+		}
+		if domain != v.domain {
+			t.Fatalf("%s: domain %d", v.addr, domain)
+		}
+		switch sa := sa.(type) {
+		case *syscall.SockaddrInet4:
+			if sa.Port != v.port {
+				t.Fatalf("%s: port %d", v.addr, sa.Port)
+			}
+		case *syscall.SockaddrInet6:
+			if sa.Port != v.port {
+				t.Fatalf("%s: port %d", v.addr, sa.Port)
+			}
+			if v.addr[1] == 'f' && sa.ZoneId == 0 {
+				t.Fatalf("%s: zone lost", v.addr)
+			}
+		}
+	}
+
+	if sa, _, err := resolveListenAddr(":0"); err != nil || sa.(*syscall.SockaddrInet4).Port != 0 {
+		t.Fatal("resolveListenAddr :0 failed")
+	}
+
+	invalid := []string{"::1:8080", "127.0.0.1", "127.0.0.1:0", "127.0.0.1:65536", "qq.com:80",
+		"[fe80::1%nosuchif0]:80", "[::1]"}
+	for _, addr := range invalid {
+		if _, _, err := resolveTCPAddr(addr); err == nil {
+			t.Fatalf("%s: should be invalid", addr)
+		}
+	}
+}
+
+type ipv6Conn struct {
+	IOHandle
+
+	addr chan string
+}
+
+func (c *ipv6Conn) OnOpen() bool {
+	c.addr <- netfd.RemoteAddr(c.Fd())
+	return false
+}
+func (c *ipv6Conn) OnClose() {
+	c.Destroy(c)
+}
+
+func TestAcceptorIPv6(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := make(chan string, 1)
+	_, err = NewAcceptor(r, "[::1]:8094", func() EvHandler { return &ipv6Conn{addr: addr} },
+		IPv6Only(true))
+	if err != nil {
+		t.Skip("IPv6 is unavailable: " + err.Error())
+	}
+	go r.Run()
+	defer r.Stop()
+
+	conn, err := net.Dial("tcp", "[::1]:8094")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case s := <-addr:
+		if s != conn.LocalAddr().String() {
+			t.Fatalf("RemoteAddr %s != %s", s, conn.LocalAddr().String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnOpen not called")
+	}
+}
@@ -0,0 +1,98 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxFastOpenDataSize is the max data size of Connector.ConnectData, the part not carried by
+// the SYN is written after the connection is established (before OnOpen)
+const maxFastOpenDataSize = 16 * 1024 // The default of `sysctl net.ipv4.tcp_wmem'
+
+// connSockOpts are the socket options applied to every accepted TCP fd
+type connSockOpts struct {
+	noDelay        bool
+	keepAliveIdle  int // second, SO_KEEPALIVE is enabled if > 0
+	keepAliveIntvl int
+	keepAliveCnt   int
+}
+
+func (o *connSockOpts) empty() bool {
+	return !o.noDelay && o.keepAliveIdle < 1
+}
+
+// apply sets the options on fd, the errors are ignored (best effort)
+func (o *connSockOpts) apply(fd int) {
+	if o.noDelay {
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1)
+	}
+	if o.keepAliveIdle > 0 {
+		syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1)
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_KEEPIDLE, o.keepAliveIdle)
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_KEEPINTVL, o.keepAliveIntvl)
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, unix.TCP_KEEPCNT, o.keepAliveCnt)
+	}
+}
+
+// attachReusePortCPUProg attaches a classic BPF program to the SO_REUSEPORT group of fd,
+// which selects the socket by `the CPU handling the packet % groupSize'.
+// The index of a socket is the order in which it joined the group (listen),
+// the kernel falls back to the hash if the index is out of range
+func attachReusePortCPUProg(fd, groupSize int) error {
+	prog := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: skfAdOff + skfAdCPU}, // A = cpu
+		{Code: unix.BPF_ALU | unix.BPF_MOD | unix.BPF_K, K: uint32(groupSize)},  // A %= groupSize
+		{Code: unix.BPF_RET | unix.BPF_A},                                       // return A
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	return unix.SetsockoptSockFprog(fd, syscall.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_CBPF, &fprog)
+}
+
+// Refer to linux/filter.h, SKF_AD_OFF + SKF_AD_CPU loads the current CPU id
+const (
+	skfAdOff = 0xfffff000 // -0x1000
+	skfAdCPU = 36
+)
+
+// sendtoFastOpen sends the data in the SYN by MSG_FASTOPEN (if there is a TFO cookie of the
+// server, otherwise only the cookie is requested), return the number of bytes queued
+func sendtoFastOpen(fd int, data []byte, sa syscall.Sockaddr) (int, error) {
+	var rsa syscall.RawSockaddrAny
+	nameLen, err := sockaddrToRaw(sa, &rsa)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		n, _, errno := syscall.Syscall6(syscall.SYS_SENDTO, uintptr(fd),
+			uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unix.MSG_FASTOPEN),
+			uintptr(unsafe.Pointer(&rsa)), uintptr(nameLen))
+		if errno != 0 {
+			if errno == syscall.EINTR {
+				continue
+			}
+			return 0, errno
+		}
+		return int(n), nil
+	}
+}
+
+// writeAll writes the data to the newly connected fd until it's sent completely or failed,
+// returns the number of bytes written, err is syscall.EAGAIN if the send buffer is full
+func writeAll(fd int, data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		n, err := syscall.Write(fd, data[written:])
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return written, err
+		} else if n < 1 {
+			return written, errors.New("write returns 0")
+		}
+		written += n
+	}
+	return written, nil
+}
@@ -2,18 +2,40 @@ package goev
 
 import (
 	"errors"
+	"sync/atomic"
 	"syscall"
-	"time"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
+// TimerID identifies a timer returned by ScheduleTimer/ScheduleTimerFunc, it's unique within the process
+type TimerID uint64
+
+var timerIDSeq atomic.Uint64
+
 type timerItem struct {
 	noCopy
+	id        TimerID
+	index     int // in fheap, -1 if popped
+	canceled  bool
 	expiredAt int64
 	interval  int64
-	eh        EvHandler
+	eh        EvHandler            // OnTimeout is called if fn is nil, nil if it's not bound to a handler
+	fn        func(now int64) bool // per-timer callback
+
+	// timerWheel
+	level      int8
+	prev, next *timerItem
+	list       *timerList // nil if not in the wheel
+}
+
+// timeout calls the callback
+func (ti *timerItem) timeout(now int64) bool {
+	if ti.fn != nil {
+		return ti.fn(now)
+	}
+	return ti.eh.OnTimeout(now)
 }
 
 type timer4Heap struct {
@@ -21,10 +43,14 @@ type timer4Heap struct {
 
 	tfd            int
 	timerfdSettime int64
+	clock          *evClock
 	fheap          []*timerItem
+	items          map[TimerID]*timerItem
+	expired        []*timerItem
 }
 
-func newTimer4Heap(initCap int) *timer4Heap {
+// newTimer4Heap uses the system clock if clock is nil
+func newTimer4Heap(initCap int, clock *evClock) *timer4Heap {
 	if initCap < 1 {
 		panic("timer4Heap initCap invalid!")
 	}
@@ -38,7 +64,12 @@ func newTimer4Heap(initCap int) *timer4Heap {
 	}
 	th := &timer4Heap{
 		tfd:   tfd,
+		clock: clock,
 		fheap: make([]*timerItem, 0, initCap),
+		items: make(map[TimerID]*timerItem, initCap),
+	}
+	if th.clock == nil {
+		th.clock = newEvClock(nil)
 	}
 	return th
 }
@@ -46,7 +77,14 @@ func newTimer4Heap(initCap int) *timer4Heap {
 func (th *timer4Heap) timerfd() int {
 	return th.tfd
 }
+func (th *timer4Heap) close() {
+	syscall.Close(th.tfd)
+	th.tfd = -1
+}
 func (th *timer4Heap) adjustTimerfd(delay /*millisecond*/ int64) {
+	if th.tfd < 0 { // closed
+		return
+	}
 	delay = delay * 1000 * 1000
 	if delay < 1 {
 		delay = 1 // 1 nanosecond
@@ -60,83 +98,150 @@ func (th *timer4Heap) OnRead() bool {
 	var readTimerfdV int64 = 0 // Compared to var bf [8] byte, the performance is the same
 	var readTimerfdBuf = (*(*[8]byte)(unsafe.Pointer(&readTimerfdV)))[:]
 	syscall.Read(th.tfd, readTimerfdBuf)
-	delay := th.handleExpired(time.Now().UnixMilli())
+	now := th.clock.update()
+	delay := th.handleExpired(now)
 	if delay > 0 {
 		th.adjustTimerfd(delay)
+		th.timerfdSettime = now + delay
 	}
 	return true
 }
 
-func (th *timer4Heap) schedule(eh EvHandler, delay, interval int64) error {
-	if delay < 0 || interval < 0 {
-		return errors.New("params are invalid")
-	}
-	if eh.getTimerItem() != nil {
-		return errors.New("eh had scheduled")
+// schedule adds a timer, eh.OnTimeout is called if fn is nil
+func (th *timer4Heap) schedule(eh EvHandler, fn func(now int64) bool, delay, interval int64) (TimerID, error) {
+	if delay < 0 || interval < 0 || (eh == nil && fn == nil) {
+		return 0, errors.New("params are invalid")
 	}
 
-	now := time.Now().UnixMilli()
+	now := th.clock.now
 	ti := &timerItem{
+		id:        TimerID(timerIDSeq.Add(1)),
 		expiredAt: now + delay,
 		interval:  interval,
 		eh:        eh,
+		fn:        fn,
 	}
-	th.fheap = append(th.fheap, ti)
-	th.shiftUp(len(th.fheap) - 1)
-	eh.setTimerItem(ti)
-
-	min := th.fheap[0]
-	if min.expiredAt != th.timerfdSettime {
-		th.adjustTimerfd(min.expiredAt - now)
-		th.timerfdSettime = min.expiredAt
+	th.items[ti.id] = ti
+	if eh != nil {
+		eh.addTimerItem(ti)
 	}
-
-	return nil
+	th.push(ti)
+	th.adjust(now)
+	return ti.id, nil
 }
 func (th *timer4Heap) scheduleTest(eh EvHandler, delay, interval int64) error {
 	ti := &timerItem{
+		id:        TimerID(timerIDSeq.Add(1)),
 		expiredAt: delay,
 		interval:  interval,
 		eh:        eh,
 	}
-	th.fheap = append(th.fheap, ti)
-	th.shiftUp(len(th.fheap) - 1)
-	eh.setTimerItem(ti)
+	th.items[ti.id] = ti
+	eh.addTimerItem(ti)
+	th.push(ti)
+	return nil
+}
+
+// reset changes delay/interval of the timer in place
+func (th *timer4Heap) reset(id TimerID, delay, interval int64) error {
+	if delay < 0 || interval < 0 {
+		return errors.New("params are invalid")
+	}
+	ti, ok := th.items[id]
+	if !ok {
+		return errors.New("timer not found")
+	}
+	now := th.clock.now
+	ti.expiredAt = now + delay
+	ti.interval = interval
+	if ti.index < 0 { // Popped, within its callback or waiting for it
+		th.push(ti)
+	} else {
+		th.shiftUp(ti.index)
+		th.shiftDown(ti.index)
+	}
+	th.adjust(now)
 	return nil
 }
+
+// cancel cancels all the timers of eh
 func (th *timer4Heap) cancel(eh EvHandler) {
-	ti := eh.getTimerItem()
-	if ti == nil {
-		return
+	for tis := eh.timerItems(); len(tis) > 0; tis = eh.timerItems() {
+		th.remove(tis[len(tis)-1])
+	}
+}
+func (th *timer4Heap) cancelID(id TimerID) {
+	if ti, ok := th.items[id]; ok {
+		th.remove(ti)
+	}
+}
+func (th *timer4Heap) remove(ti *timerItem) {
+	delete(th.items, ti.id)
+	if ti.eh != nil {
+		ti.eh.delTimerItem(ti)
+	}
+	ti.canceled = true
+	ti.eh, ti.fn = nil, nil
+	if ti.index >= 0 {
+		// 防止定时器时间太久导致ti回收被延迟太久, it's discarded when it surfaces
+		// No need to adjust timerfd
+		ti.expiredAt = 1
+		th.shiftUp(ti.index)
+	}
+}
+
+func (th *timer4Heap) push(ti *timerItem) {
+	ti.index = len(th.fheap)
+	th.fheap = append(th.fheap, ti)
+	th.shiftUp(ti.index)
+}
+func (th *timer4Heap) adjust(now int64) {
+	min := th.fheap[0]
+	if min.expiredAt != th.timerfdSettime {
+		th.adjustTimerfd(min.expiredAt - now)
+		th.timerfdSettime = min.expiredAt
 	}
-	ti.eh = nil
-	ti.expiredAt = 1 // 防止定时器时间太久导致ti回收被延迟太久(这是不确定的, 因为没有改变ti 在heap的位置)
-	// No need to adjust timerfd
-	eh.setTimerItem(nil)
 }
+
 func (th *timer4Heap) handleExpired(now int64) int64 {
 	if len(th.fheap) == 0 {
 		return 0
 	}
 
-	delta := int64(-1)
+	// Pop all first, so the callbacks can schedule/reset/cancel timers freely
 	var item *timerItem
 	for {
-		item, delta = th.popOne(now, 2) // 2 是误差范围 表示在0~2之间到期的都会马上执行
+		item, _ = th.popOne(now, 2) // 2 是误差范围 表示在0~2之间到期的都会马上执行
 		if item == nil {
 			break
 		}
-		if item.eh == nil { // canceled
+		th.expired = append(th.expired, item)
+	}
+	for i, item := range th.expired {
+		th.expired[i] = nil
+		if item.canceled || item.index >= 0 { // Canceled or reset by the previous callbacks
+			continue
+		}
+		keep := item.timeout(now)
+		if item.canceled || item.index >= 0 { // Canceled or reset within the callback
 			continue
 		}
-		if item.eh.OnTimeout(now) == true && item.interval > 0 {
+		if keep == true && item.interval > 0 {
 			item.expiredAt = now + item.interval
-			th.fheap = append(th.fheap, item)
-			th.shiftUp(len(th.fheap) - 1)
+			th.push(item)
 		} else {
-			item.eh.setTimerItem(nil) // release timerItem
+			th.remove(item) // release timerItem
 		}
 	}
+	th.expired = th.expired[:0]
+
+	if len(th.fheap) == 0 {
+		return 0
+	}
+	delta := th.fheap[0].expiredAt - now
+	if delta < 1 {
+		delta = 1
+	}
 	return delta
 }
 
@@ -156,9 +261,14 @@ func (th *timer4Heap) popOne(now, errorVal int64) (*timerItem, int64) {
 	}
 	last := len(th.fheap) - 1
 	th.fheap[0] = th.fheap[last]
+	th.fheap[0].index = 0
+	th.fheap[last] = nil
 	th.fheap = th.fheap[:last]
+	min.index = -1
 
-	th.shiftDown(0)
+	if last > 0 {
+		th.shiftDown(0)
+	}
 
 	return min, 0
 }
@@ -168,6 +278,7 @@ func (th *timer4Heap) shiftUp(index int) {
 
 	for index > 0 && th.fheap[index].expiredAt < th.fheap[parent].expiredAt {
 		th.fheap[index], th.fheap[parent] = th.fheap[parent], th.fheap[index]
+		th.fheap[index].index, th.fheap[parent].index = index, parent
 		index = parent
 		parent = (index - 1) / 4
 	}
@@ -190,6 +301,7 @@ func (th *timer4Heap) shiftDown(index int) {
 
 			if smallest != index {
 				th.fheap[index], th.fheap[smallest] = th.fheap[smallest], th.fheap[index]
+				th.fheap[index].index, th.fheap[smallest].index = index, smallest
 				index = smallest
 			} else {
 				break
@@ -35,7 +35,7 @@ func (t *fheapTimer) OnTimeout(now int64) bool {
 }
 
 func TestTimer4Heap_Algo(t *testing.T) {
-	t4h := newTimer4Heap(1024)
+	t4h := newTimer4Heap(1024, nil)
 
 	var obj EvHandler
 	for i := 0; i < 200; i++ {
@@ -71,3 +71,173 @@ func TestTimer4Heap(t *testing.T) {
 	}()
 	time.Sleep(time.Second * 10)
 }
+
+type multiTimer struct {
+	IOHandle
+
+	timeoutC chan int64
+}
+
+func (t *multiTimer) OnTimeout(now int64) bool {
+	t.timeoutC <- now
+	return false
+}
+func (t *multiTimer) OnClose() {
+	t.Destroy(t)
+}
+
+func TestTimerMulti(t *testing.T) {
+	testTimerMulti(t)
+}
+
+func testTimerMulti(t *testing.T, opts ...Option) {
+	reactor, err := NewReactor(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go reactor.Run()
+	defer reactor.Stop()
+
+	fd, _ := unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	mt := &multiTimer{timeoutC: make(chan int64, 1)}
+	if err = reactor.AddEvHandler(mt, fd, EvIn); err != nil {
+		t.Fatal(err)
+	}
+	heartbeatC, resetC, canceledC := make(chan int, 16), make(chan int64, 1), make(chan struct{}, 1)
+	begin := time.Now().UnixMilli()
+	mt.Post(func() {
+		mt.ScheduleTimer(mt, 30, 0) // OnTimeout
+		n := 0
+		mt.ScheduleTimerFunc(10, 10, func(now int64) bool { // heartbeat
+			n++
+			heartbeatC <- n
+			return n < 5
+		})
+		id, _ := mt.ScheduleTimerFunc(20, 0, func(now int64) bool {
+			canceledC <- struct{}{}
+			return false
+		})
+		mt.CancelTimerID(id)
+		id, _ = mt.ScheduleTimerFunc(10, 0, func(now int64) bool {
+			resetC <- now
+			return false
+		})
+		mt.ResetTimer(id, 80, 0)
+	})
+
+	select {
+	case <-mt.timeoutC:
+	case <-time.After(time.Second):
+		t.Fatal("OnTimeout not called")
+	}
+	for i := 1; i <= 5; i++ {
+		select {
+		case n := <-heartbeatC:
+			if n != i {
+				t.Fatalf("heartbeat %d, want %d", n, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("heartbeat not called")
+		}
+	}
+	select {
+	case now := <-resetC:
+		if now-begin < 70 {
+			t.Fatalf("reset timer expired after %d msec", now-begin)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reset timer not called")
+	}
+	select {
+	case <-canceledC:
+		t.Fatal("canceled timer called")
+	case <-heartbeatC:
+		t.Fatal("heartbeat called after returning false")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type fakeClock struct {
+	now int64
+}
+
+func (c *fakeClock) UnixMilli() int64 {
+	return c.now
+}
+
+func TestTimerClock(t *testing.T) {
+	for _, backend := range []int{TimerHeap, TimerWheel} {
+		fc := &fakeClock{now: 1000000}
+		clock := newEvClock(fc)
+		var timer evTimer
+		if backend == TimerWheel {
+			timer = newTimerWheel(16, clock)
+		} else {
+			timer = newTimer4Heap(16, clock)
+		}
+		var fired []int64
+		timer.schedule(nil, func(now int64) bool {
+			fired = append(fired, now)
+			return len(fired) < 3
+		}, 100, 50)
+
+		for _, step := range []int64{97, 3, 47, 3, 1000, 1000} { // timer4Heap tolerates 2ms
+			fc.now += step
+			timer.OnRead() // Driven by the clock, not timerfd
+		}
+		want := []int64{1000100, 1000150, 1001150}
+		if len(fired) != len(want) || timer.size() != 0 {
+			t.Fatalf("backend %d fired %v, size %d", backend, fired, timer.size())
+		}
+		for i := range want {
+			if fired[i] != want[i] {
+				t.Fatalf("backend %d fired %v, want %v", backend, fired, want)
+			}
+		}
+		timer.close()
+	}
+}
+
+type benchTimer struct {
+	IOHandle
+}
+
+func (t *benchTimer) OnTimeout(now int64) bool {
+	return true
+}
+
+func benchmarkTimerSchedule(b *testing.B, timer evTimer) {
+	defer timer.close()
+	eh := &benchTimer{}
+	delays := make([]int64, 1024)
+	for i := range delays {
+		delays[i] = rand.Int63() % (60 * 1000)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timer.schedule(eh, nil, delays[i%len(delays)], 0)
+		if i%1024 == 1023 {
+			timer.cancel(eh)
+		}
+	}
+}
+
+func benchmarkTimerScheduleCancel(b *testing.B, timer evTimer) {
+	defer timer.close()
+	eh := &benchTimer{}
+	for i := 0; i < 100000; i++ { // A large number of timers, e.g. heartbeats
+		timer.schedule(eh, nil, rand.Int63()%(60*1000)+1000, 0)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id, _ := timer.schedule(nil, func(int64) bool { return false }, 30*1000, 0)
+		timer.cancelID(id)
+	}
+}
+
+func BenchmarkTimer4HeapSchedule(b *testing.B) {
+	benchmarkTimerSchedule(b, newTimer4Heap(1024, nil))
+}
+func BenchmarkTimer4HeapScheduleCancel(b *testing.B) {
+	benchmarkTimerScheduleCancel(b, newTimer4Heap(1024, nil))
+}
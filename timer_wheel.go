@@ -0,0 +1,364 @@
+package goev
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// evTimer is the timer backend of evPoll, woken up by timerfd
+type evTimer interface {
+	EvHandler
+
+	timerfd() int
+	close()
+	schedule(eh EvHandler, fn func(now int64) bool, delay, interval int64) (TimerID, error)
+	reset(id TimerID, delay, interval int64) error
+	cancel(eh EvHandler)
+	cancelID(id TimerID)
+	size() int
+}
+
+const (
+	// TimerHeap is the 4-ary heap timer backend (default), O(log n) per schedule/cancel
+	TimerHeap = iota
+
+	// TimerWheel is the hierarchical timing wheel timer backend, O(1) per schedule/cancel,
+	// better for a large number of timers (e.g. heartbeats of millions of connections)
+	TimerWheel
+)
+
+// The levels of the wheel refer to the Linux kernel timer wheel, 1 tick = 1 millisecond.
+// Level 0 has 256 slots, level 1~4 have 64 slots, covering 2^32 milliseconds (about 49.7 days).
+// The longer timer is re-queued when it surfaces
+const (
+	twLevel0Bits = 8
+	twLevelBits  = 6
+	twLevels     = 5
+	twLevel0Size = 1 << twLevel0Bits
+	twLevelSize  = 1 << twLevelBits
+	twMaxDelta   = 1<<(twLevel0Bits+twLevelBits*(twLevels-1)) - 1
+)
+
+type timerList struct {
+	head *timerItem
+}
+
+func (l *timerList) pushBack(ti *timerItem) {
+	// The order in a slot is unimportant, push front for O(1)
+	ti.prev, ti.next, ti.list = nil, l.head, l
+	if l.head != nil {
+		l.head.prev = ti
+	}
+	l.head = ti
+}
+func (l *timerList) remove(ti *timerItem) {
+	if ti.prev != nil {
+		ti.prev.next = ti.next
+	} else {
+		l.head = ti.next
+	}
+	if ti.next != nil {
+		ti.next.prev = ti.prev
+	}
+	ti.prev, ti.next, ti.list = nil, nil, nil
+}
+
+type timerWheel struct {
+	IOHandle
+
+	tfd            int
+	timerfdSettime int64
+	clock          *evClock
+	cur            int64 // unix millisecond, all the ticks <= cur have been processed
+	num            int
+	levelNum       [twLevels]int
+	level0         [twLevel0Size]timerList
+	levels         [twLevels - 1][twLevelSize]timerList
+	items          map[TimerID]*timerItem
+	expired        []*timerItem
+}
+
+// newTimerWheel uses the system clock if clock is nil
+func newTimerWheel(initCap int, clock *evClock) *timerWheel {
+	if initCap < 1 {
+		panic("timerWheel initCap invalid!")
+	}
+	tfd, err := unix.TimerfdCreate(unix.CLOCK_BOOTTIME, unix.TFD_NONBLOCK|unix.TFD_CLOEXEC)
+	if err != nil {
+		if err == unix.ENOSYS {
+			panic("timerfd_create system call not implemented")
+		}
+		panic("TimerfdCreate: " + err.Error())
+	}
+	if clock == nil {
+		clock = newEvClock(nil)
+	}
+	return &timerWheel{
+		tfd:   tfd,
+		clock: clock,
+		cur:   clock.now,
+		items: make(map[TimerID]*timerItem, initCap),
+	}
+}
+
+func (tw *timerWheel) timerfd() int {
+	return tw.tfd
+}
+func (tw *timerWheel) close() {
+	syscall.Close(tw.tfd)
+	tw.tfd = -1
+}
+func (tw *timerWheel) size() int {
+	return tw.num
+}
+
+// adjustTimerfd wakes up at `at' (unix millisecond)
+func (tw *timerWheel) adjustTimerfd(at, now int64) {
+	if tw.tfd < 0 {
+		return
+	}
+	tw.timerfdSettime = at
+	delay := (at - now) * 1000 * 1000
+	if delay < 1 {
+		delay = 1 // 1 nanosecond
+	}
+	timeSpec := unix.ItimerSpec{
+		Value: unix.NsecToTimespec(delay),
+	}
+	unix.TimerfdSettime(tw.tfd, 0 /*Relative time*/, &timeSpec, nil)
+}
+
+// nextExpiration returns the earliest expiration in level 0 (cur if the current slot is not
+// empty), or the next cascading time of the lowest non-empty level (a lower bound)
+func (tw *timerWheel) nextExpiration() int64 {
+	next := int64(-1)
+	if tw.levelNum[0] > 0 {
+		for i := int64(0); i < twLevel0Size; i++ {
+			if tw.level0[(tw.cur+i)&(twLevel0Size-1)].head != nil {
+				next = tw.cur + i
+				break
+			}
+		}
+	}
+	for l := 1; l < twLevels; l++ {
+		if tw.levelNum[l] > 0 {
+			shift := twLevel0Bits + twLevelBits*(l-1)
+			boundary := (tw.cur>>shift + 1) << shift
+			if next < 0 || boundary < next {
+				next = boundary
+			}
+			break
+		}
+	}
+	return next
+}
+
+func (tw *timerWheel) OnRead() bool {
+	var readTimerfdV int64 = 0
+	var readTimerfdBuf = (*(*[8]byte)(unsafe.Pointer(&readTimerfdV)))[:]
+	syscall.Read(tw.tfd, readTimerfdBuf)
+	now := tw.clock.update()
+	tw.handleExpired(now)
+	if tw.num > 0 {
+		tw.adjustTimerfd(tw.nextExpiration(), now)
+	}
+	return true
+}
+
+func (tw *timerWheel) schedule(eh EvHandler, fn func(now int64) bool, delay, interval int64) (TimerID, error) {
+	if delay < 0 || interval < 0 || (eh == nil && fn == nil) {
+		return 0, errors.New("params are invalid")
+	}
+	now := tw.clock.now
+	if tw.num == 0 && now > tw.cur {
+		tw.cur = now // Nothing to process
+	}
+	ti := &timerItem{
+		id:        TimerID(timerIDSeq.Add(1)),
+		index:     -1,
+		expiredAt: now + delay,
+		interval:  interval,
+		eh:        eh,
+		fn:        fn,
+	}
+	tw.items[ti.id] = ti
+	if eh != nil {
+		eh.addTimerItem(ti)
+	}
+	tw.add(ti)
+	tw.wakeup(ti, now)
+	return ti.id, nil
+}
+
+func (tw *timerWheel) reset(id TimerID, delay, interval int64) error {
+	if delay < 0 || interval < 0 {
+		return errors.New("params are invalid")
+	}
+	ti, ok := tw.items[id]
+	if !ok {
+		return errors.New("timer not found")
+	}
+	now := tw.clock.now
+	if ti.list != nil {
+		tw.unlink(ti)
+	}
+	ti.expiredAt = now + delay
+	ti.interval = interval
+	tw.add(ti)
+	tw.wakeup(ti, now)
+	return nil
+}
+
+// wakeup makes sure timerfd is not later than ti, O(1) unless timerfd has expired
+func (tw *timerWheel) wakeup(ti *timerItem, now int64) {
+	if tw.timerfdSettime <= now {
+		// The expiration may not have been handled yet (now is cached), don't delay the due timers
+		at := ti.expiredAt
+		if next := tw.nextExpiration(); next >= 0 && next < at {
+			at = next
+		}
+		tw.adjustTimerfd(at, now)
+	} else if ti.expiredAt < tw.timerfdSettime {
+		tw.adjustTimerfd(ti.expiredAt, now)
+	}
+}
+
+func (tw *timerWheel) cancel(eh EvHandler) {
+	for tis := eh.timerItems(); len(tis) > 0; tis = eh.timerItems() {
+		tw.remove(tis[len(tis)-1])
+	}
+}
+func (tw *timerWheel) cancelID(id TimerID) {
+	if ti, ok := tw.items[id]; ok {
+		tw.remove(ti)
+	}
+}
+
+// remove releases the timer immediately, no need to adjust timerfd
+func (tw *timerWheel) remove(ti *timerItem) {
+	delete(tw.items, ti.id)
+	if ti.eh != nil {
+		ti.eh.delTimerItem(ti)
+	}
+	ti.canceled = true
+	ti.eh, ti.fn = nil, nil
+	if ti.list != nil {
+		tw.unlink(ti)
+	}
+}
+
+// add puts ti into the slot according to expiredAt, refer to Linux kernel internal_add_timer
+func (tw *timerWheel) add(ti *timerItem) {
+	expires := ti.expiredAt
+	delta := expires - tw.cur
+	if delta < 1 { // Expired, put it in the current slot (processed by the next advance)
+		expires = tw.cur
+		delta = 0
+	} else if delta > twMaxDelta {
+		expires = tw.cur + twMaxDelta
+		delta = twMaxDelta
+	}
+	if delta < twLevel0Size {
+		ti.level = 0
+		tw.level0[expires&(twLevel0Size-1)].pushBack(ti)
+	} else {
+		l := 1
+		for ; l < twLevels-1; l++ {
+			if delta < 1<<(twLevel0Bits+twLevelBits*l) {
+				break
+			}
+		}
+		shift := twLevel0Bits + twLevelBits*(l-1)
+		ti.level = int8(l)
+		tw.levels[l-1][(expires>>shift)&(twLevelSize-1)].pushBack(ti)
+	}
+	tw.levelNum[ti.level]++
+	tw.num++
+}
+func (tw *timerWheel) unlink(ti *timerItem) {
+	ti.list.remove(ti)
+	tw.levelNum[ti.level]--
+	tw.num--
+}
+
+// cascade moves the timers of the current slot of level l to the lower levels,
+// return true if the slot index is 0 (the higher level needs cascading too)
+func (tw *timerWheel) cascade(l int) bool {
+	shift := twLevel0Bits + twLevelBits*(l-1)
+	idx := (tw.cur >> shift) & (twLevelSize - 1)
+	list := &tw.levels[l-1][idx]
+	for ti := list.head; ti != nil; ti = list.head {
+		tw.unlink(ti)
+		tw.add(ti)
+	}
+	return idx == 0
+}
+
+// expire moves the timers of the current level 0 slot to tw.expired
+func (tw *timerWheel) expire() {
+	list := &tw.level0[tw.cur&(twLevel0Size-1)]
+	for ti := list.head; ti != nil; ti = list.head {
+		tw.unlink(ti)
+		if ti.expiredAt > tw.cur { // Longer than twMaxDelta
+			tw.add(ti)
+			continue
+		}
+		tw.expired = append(tw.expired, ti)
+	}
+}
+
+// advance processes the ticks up to now, the expired timers are appended to tw.expired
+func (tw *timerWheel) advance(now int64) {
+	tw.expire() // Added after the current tick was processed
+	for tw.cur < now {
+		if tw.num == 0 {
+			tw.cur = now
+			return
+		}
+		// Skip the ticks that nothing happens
+		if tw.levelNum[0] == 0 {
+			l := 1
+			for ; l < twLevels-1 && tw.levelNum[l] == 0; l++ {
+			}
+			shift := twLevel0Bits + twLevelBits*(l-1)
+			boundary := (tw.cur>>shift + 1) << shift
+			if boundary > now {
+				tw.cur = now
+				return
+			}
+			tw.cur = boundary - 1
+		}
+
+		tw.cur++
+		if tw.cur&(twLevel0Size-1) == 0 {
+			for l := 1; l < twLevels && tw.cascade(l); l++ {
+			}
+		}
+		tw.expire()
+	}
+}
+
+func (tw *timerWheel) handleExpired(now int64) {
+	tw.advance(now)
+
+	for i, item := range tw.expired {
+		tw.expired[i] = nil
+		if item.canceled || item.list != nil { // Canceled or reset by the previous callbacks
+			continue
+		}
+		keep := item.timeout(now)
+		if item.canceled || item.list != nil { // Canceled or reset within the callback
+			continue
+		}
+		if keep == true && item.interval > 0 {
+			item.expiredAt = now + item.interval
+			tw.add(item)
+		} else {
+			tw.remove(item) // release timerItem
+		}
+	}
+	tw.expired = tw.expired[:0]
+}
@@ -0,0 +1,86 @@
+package goev
+
+import (
+	"math/rand"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTimerWheel_Algo(t *testing.T) {
+	tw := newTimerWheel(1024, nil)
+	defer tw.close()
+
+	base := time.Now().UnixMilli()
+	expiredAt := make(map[TimerID]int64)
+	firedAt := make(map[TimerID]int64)
+	var canceled []TimerID
+	var now int64
+	for i := 0; i < 20000; i++ {
+		delay := rand.Int63() % 100000 // Levels 0~2
+		var id TimerID
+		id, _ = tw.schedule(nil, func(int64) bool {
+			firedAt[id] = now
+			return false
+		}, delay, 0)
+		expiredAt[id] = tw.items[id].expiredAt
+		if i%4 == 0 {
+			canceled = append(canceled, id)
+		}
+	}
+	for _, id := range canceled {
+		tw.cancelID(id)
+		delete(expiredAt, id)
+	}
+	if tw.size() != len(expiredAt) {
+		t.Fatalf("size %d, want %d", tw.size(), len(expiredAt))
+	}
+
+	end := base + 100000 + 1000
+	for now = base; now < end; {
+		step := rand.Int63()%300 + 1
+		tw.handleExpired(now)
+		for id, at := range expiredAt {
+			fired, ok := firedAt[id]
+			if at <= now && !ok {
+				t.Fatalf("timer expired at %d not fired at %d", at-base, now-base)
+			}
+			if ok && fired < at {
+				t.Fatalf("timer expired at %d fired early at %d", at-base, fired-base)
+			}
+		}
+		now += step
+	}
+	if len(firedAt) != len(expiredAt) || tw.size() != 0 {
+		t.Fatalf("fired %d, want %d, size %d", len(firedAt), len(expiredAt), tw.size())
+	}
+}
+
+func TestTimerWheelDueNotDelayed(t *testing.T) {
+	tw := newTimerWheel(16, nil)
+	defer tw.close()
+
+	a, _ := tw.schedule(nil, func(int64) bool { return false }, 2, 0)
+	at := tw.items[a].expiredAt
+	tw.clock.now += 3 // A is due, but the timerfd event is not handled yet
+	tw.schedule(nil, func(int64) bool { return false }, 10*1000, 0)
+	if tw.timerfdSettime > at {
+		t.Fatalf("timerfd moved to %d, the due timer expired at %d", tw.timerfdSettime, at)
+	}
+	time.Sleep(20 * time.Millisecond)
+	var buf [8]byte
+	if n, err := syscall.Read(tw.timerfd(), buf[:]); n != 8 || err != nil {
+		t.Fatalf("timerfd not expired: %v", err)
+	}
+}
+
+func TestTimerWheel(t *testing.T) {
+	testTimerMulti(t, TimerBackendType(TimerWheel))
+}
+
+func BenchmarkTimerWheelSchedule(b *testing.B) {
+	benchmarkTimerSchedule(b, newTimerWheel(1024, nil))
+}
+func BenchmarkTimerWheelScheduleCancel(b *testing.B) {
+	benchmarkTimerScheduleCancel(b, newTimerWheel(1024, nil))
+}
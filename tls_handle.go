@@ -0,0 +1,374 @@
+package goev
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/shaovie/goev/netfd"
+)
+
+// tlsHandshakeTimeout the handshake will be aborted(close the conn) if it isn't completed in time,
+// refer to options.TLSHandshakeTimeout
+const tlsHandshakeTimeout = 10 * 1000 // millisecond
+
+type tlsHandler interface {
+	InitTLS(cfg *tls.Config, isClient bool)
+	setHandshakeTimeout(msec int64)
+}
+
+// TLSHandle is the base class of TLS io event handling objects, use it instead of IOHandle.
+//
+// The handshake is started on the first Read/Write/AsyncWrite (or by calling Handshake), and is
+// performed in a temporary goroutine driven by OnRead (the handshake messages are written within
+// the evpoll coroutine), the rest is completely non-blocking within the evpoll coroutine.
+// crypto/tls can't resume an interrupted handshake, so the goroutine is unavoidable, but on the
+// server side it's not created until the first bytes (ClientHello) arrive, and it exits when the
+// handshake times out (options.TLSHandshakeTimeout, default 10s)
+//
+// Read returns the decrypted data, and n = -1, err = syscall.EAGAIN if there is no complete
+// record yet (e.g. during the handshake). Write and AsyncWrite encrypt the data, the data written
+// before the handshake completes will be sent after it. So OnRead is generally like:
+//
+//	func (c *Conn) OnRead() bool {
+//	    buf, n, err := c.Read()
+//	    if n == 0 || (n < 0 && err != syscall.EAGAIN) { // Abnormal connection
+//	        return false
+//	    }
+//	    ...
+//	}
+//
+// ALPN, SNI-based certificate selection (GetCertificate) and session resumption are all
+// configured by tls.Config, refer to crypto/tls
+type TLSHandle struct {
+	IOHandle
+
+	handshaking bool
+	handshaked  bool
+	timeout     int64 // millisecond
+	tlsConn     *tls.Conn
+	tlsIO       *tlsIO
+	pending     [][]byte // plaintext written before the handshake completes
+}
+
+// InitTLS enable TLS on the handle, must be called before it's registered with the reactor.
+// It's called automatically by Acceptor if options.TLSConfig was set.
+func (t *TLSHandle) InitTLS(cfg *tls.Config, isClient bool) {
+	t.handshaking, t.handshaked, t.pending = false, false, nil
+	t.tlsIO = &tlsIO{h: t}
+	t.tlsIO.cond = sync.NewCond(&t.tlsIO.mtx)
+	if isClient {
+		t.tlsConn = tls.Client(t.tlsIO, cfg)
+	} else {
+		t.tlsConn = tls.Server(t.tlsIO, cfg)
+	}
+}
+
+func (t *TLSHandle) setHandshakeTimeout(msec int64) {
+	t.timeout = msec
+}
+
+// TLSConnectionState returns basic TLS details about the connection (e.g. NegotiatedProtocol
+// for ALPN, ServerName for SNI, DidResume), ok is false if the handshake is not complete
+//
+// Can only be used within the poller goroutine
+func (t *TLSHandle) TLSConnectionState() (cs tls.ConnectionState, ok bool) {
+	if !t.handshaked {
+		return
+	}
+	return t.tlsConn.ConnectionState(), true
+}
+
+// Handshake starts the handshake explicitly (e.g. for a client that doesn't write first),
+// must be called after it's registered with the reactor.
+//
+// Can only be used within the poller goroutine
+func (t *TLSHandle) Handshake() {
+	if t.tlsConn == nil || t.handshaking || t.handshaked {
+		return
+	}
+	t.handshaking = true
+	timeout := t.timeout
+	if timeout < 1 {
+		timeout = tlsHandshakeTimeout
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+		err := t.tlsConn.HandshakeContext(ctx)
+		cancel()
+		t.Post(func() {
+			t.onHandshakeDone(err)
+		})
+	}()
+}
+
+func (t *TLSHandle) onHandshakeDone(err error) {
+	t.handshaking = false
+	if t.Fd() < 1 { // closed
+		return
+	}
+	if err != nil {
+		t.closeInPoll()
+		return
+	}
+	t.tlsIO.setInLoop()
+	t.handshaked = true
+	for _, bf := range t.pending {
+		if _, err = t.Write(bf); err != nil {
+			t.closeInPoll()
+			return
+		}
+	}
+	t.pending = nil
+	// The application data may arrive with the handshake messages, and has been
+	// read into tls.Conn by the handshake goroutine
+	t.resumeRead()
+}
+
+func (t *TLSHandle) resumeRead() {
+	if t.Fd() < 1 || t.eh == nil {
+		return
+	}
+	if t.eh.OnRead() == false {
+		t.closeInPoll()
+	}
+}
+
+// Read returns the decrypted data, it uses evPollReadBuff too.
+//
+// Can only be used within the poller goroutine
+func (t *TLSHandle) Read() (bf []byte, n int, err error) {
+	if t.tlsConn == nil {
+		return t.IOHandle.Read()
+	}
+	raw, rn, rerr := t.IOHandle.Read()
+	if rn > 0 {
+		t.tlsIO.feed(raw)
+	} else if rn == 0 {
+		t.tlsIO.Close() // Peer closed
+	}
+	if !t.handshaked {
+		if rn == 0 || (rn < 0 && rerr != syscall.EAGAIN) {
+			return nil, rn, rerr
+		}
+		t.Handshake()
+		return nil, -1, syscall.EAGAIN
+	}
+
+	buf := t.ep.evPollReadBuff // The raw data has been copied to tlsIO
+	var e error
+	for n < len(buf) {
+		var m int
+		m, e = t.tlsConn.Read(buf[n:])
+		n += m
+		if e != nil || m == 0 {
+			break
+		}
+	}
+	if n == len(buf) { // There may be more data in tls.Conn
+		t.Post(t.resumeRead)
+	}
+	if len(t.tlsIO.out) > 0 { // Written by tls.Conn.Read, e.g. the KeyUpdate response or an alert
+		t.IOHandle.Write(t.tlsIO.out) // It has been copied if not sent completely
+		t.tlsIO.out = t.tlsIO.out[:0]
+	}
+	if n > 0 {
+		return buf[:n], n, nil
+	}
+	if ne, ok := e.(net.Error); ok && ne.Temporary() {
+		if rn < 0 && rerr != syscall.EAGAIN {
+			return nil, -1, rerr
+		}
+		return nil, -1, syscall.EAGAIN
+	}
+	if e == io.EOF || rn == 0 {
+		return nil, 0, nil
+	}
+	return nil, -1, e
+}
+
+// Write encrypts and writes the data synchronously, same as IOHandle.Write
+//
+// Can only be used within the poller goroutine
+func (t *TLSHandle) Write(bf []byte) (n int, err error) {
+	if t.tlsConn == nil {
+		return t.IOHandle.Write(bf)
+	}
+	if t.Fd() < 1 {
+		return 0, syscall.EBADF
+	}
+	if !t.handshaked {
+		t.pending = append(t.pending, append([]byte(nil), bf...))
+		t.Handshake()
+		return len(bf), nil
+	}
+	if n, err = t.tlsConn.Write(bf); err != nil {
+		return
+	}
+	_, err = t.IOHandle.Write(t.tlsIO.out) // It has been copied if not sent completely
+	t.tlsIO.out = t.tlsIO.out[:0]
+	return
+}
+
+// Writev encrypts the bufs and writes them synchronously (one write syscall), same as IOHandle.Writev
+//
+// Can only be used within the poller goroutine
+func (t *TLSHandle) Writev(bufs [][]byte) (n int, err error) {
+	if t.tlsConn == nil {
+		return t.IOHandle.Writev(bufs)
+	}
+	if t.Fd() < 1 {
+		return 0, syscall.EBADF
+	}
+	if !t.handshaked {
+		for _, bf := range bufs {
+			t.pending = append(t.pending, append([]byte(nil), bf...))
+			n += len(bf)
+		}
+		t.Handshake()
+		return n, nil
+	}
+	for _, bf := range bufs {
+		var m int
+		m, err = t.tlsConn.Write(bf) // Appended to tlsIO.out
+		n += m
+		if err != nil {
+			break
+		}
+	}
+	if len(t.tlsIO.out) > 0 {
+		_, werr := t.IOHandle.Write(t.tlsIO.out) // It has been copied if not sent completely
+		t.tlsIO.out = t.tlsIO.out[:0]
+		if err == nil {
+			err = werr
+		}
+	}
+	return
+}
+
+// SendFile is not supported if TLS is enabled (the data must be encrypted)
+func (t *TLSHandle) SendFile(f *os.File, offset, count int64, done func(sent int64, err error)) error {
+	if t.tlsConn == nil {
+		return t.IOHandle.SendFile(f, offset, count, done)
+	}
+	return errors.New("goev: SendFile is not supported by TLS")
+}
+
+func (t *TLSHandle) asyncOrderedWrite(eh EvHandler, abf asyncWriteBuf) {
+	if t.tlsConn == nil {
+		t.IOHandle.asyncOrderedWrite(eh, abf)
+		return
+	}
+	if t.Fd() > 0 {
+		t.Write(abf.buf[abf.writen:abf.len]) // It's copied
+	}
+	abf.release(eh)
+}
+
+// Destroy aborts the handshake and releases the resources, refer to IOHandle.Destroy
+func (t *TLSHandle) Destroy(eh EvHandler) {
+	if t.tlsIO != nil {
+		t.tlsIO.Close()
+	}
+	t.IOHandle.Destroy(eh)
+}
+
+// tlsWouldBlock is a temporary error, tls.Conn doesn't record it (refer to crypto/tls readRecord)
+type tlsWouldBlock struct{}
+
+func (tlsWouldBlock) Error() string   { return "goev: tls would block" }
+func (tlsWouldBlock) Timeout() bool   { return true }
+func (tlsWouldBlock) Temporary() bool { return true }
+
+// tlsIO is the transport of tls.Conn, the raw data is fed by TLSHandle.Read.
+//
+// During the handshake, Read blocks the handshake goroutine and Write posts data to evPoll,
+// after that, Read returns tlsWouldBlock if no data and Write buffers data in out.
+type tlsIO struct {
+	mtx    sync.Mutex
+	cond   *sync.Cond
+	in     []byte
+	out    []byte
+	inLoop bool
+	closed bool
+
+	h *TLSHandle
+}
+
+func (c *tlsIO) feed(bf []byte) {
+	c.mtx.Lock()
+	c.in = append(c.in, bf...)
+	c.mtx.Unlock()
+	c.cond.Signal()
+}
+func (c *tlsIO) setInLoop() {
+	c.mtx.Lock()
+	c.inLoop = true
+	c.mtx.Unlock()
+}
+
+func (c *tlsIO) Read(b []byte) (int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for len(c.in) == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if c.inLoop {
+			return 0, tlsWouldBlock{}
+		}
+		c.cond.Wait()
+	}
+	n := copy(b, c.in)
+	c.in = c.in[n:]
+	if len(c.in) == 0 {
+		c.in = nil
+	}
+	return n, nil
+}
+
+func (c *tlsIO) Write(b []byte) (int, error) {
+	c.mtx.Lock()
+	inLoop, closed := c.inLoop, c.closed
+	c.mtx.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	if inLoop {
+		c.out = append(c.out, b...)
+		return len(b), nil
+	}
+	// In the handshake goroutine
+	bf := append([]byte(nil), b...)
+	if err := c.h.Post(func() {
+		c.h.IOHandle.Write(bf)
+	}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *tlsIO) Close() error {
+	c.mtx.Lock()
+	c.closed = true
+	c.mtx.Unlock()
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *tlsIO) LocalAddr() net.Addr                { return tlsAddr(netfd.LocalAddr(c.h.Fd())) }
+func (c *tlsIO) RemoteAddr() net.Addr               { return tlsAddr(netfd.RemoteAddr(c.h.Fd())) }
+func (c *tlsIO) SetDeadline(t time.Time) error      { return nil }
+func (c *tlsIO) SetReadDeadline(t time.Time) error  { return nil }
+func (c *tlsIO) SetWriteDeadline(t time.Time) error { return nil }
+
+type tlsAddr string
+
+func (a tlsAddr) Network() string { return "tcp" }
+func (a tlsAddr) String() string  { return string(a) }
@@ -0,0 +1,179 @@
+package goev
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type tlsEcho struct {
+	TLSHandle
+
+	r *Reactor
+}
+
+func (c *tlsEcho) OnOpen() bool {
+	if err := c.r.AddEvHandler(c, c.Fd(), EvIn); err != nil {
+		return false
+	}
+	return true
+}
+func (c *tlsEcho) OnRead() bool {
+	buf, n, err := c.Read()
+	if n == 0 || (n < 0 && err != syscall.EAGAIN) {
+		return false
+	}
+	if n > 0 {
+		cs, _ := c.TLSConnectionState()
+		c.Writev([][]byte{[]byte(cs.NegotiatedProtocol + ":"), buf}) // Encrypted, not raw writev
+	}
+	return true
+}
+func (c *tlsEcho) OnWrite() bool {
+	c.AsyncOrderedFlush(c)
+	return true
+}
+func (c *tlsEcho) OnClose() {
+	c.Destroy(c)
+}
+
+func newTestCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "goev"},
+		DNSNames:     []string{"goev.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSHandle(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t)},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	_, err = NewAcceptor(r, "127.0.0.1:8096", func() EvHandler { return &tlsEcho{r: r} },
+		TLSConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	conn, err := tls.Dial("tcp", "127.0.0.1:8096", &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "goev.test",
+		NextProtos:         []string{"http/1.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 2))
+
+	buf := make([]byte, 64)
+	for _, s := range []string{"hello", "goev"} {
+		if _, err = conn.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		want := "http/1.1:" + s
+		got := ""
+		for len(got) < len(want) {
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got += string(buf[:n])
+		}
+		if got != want {
+			t.Fatalf("echo %q != %q", got, want)
+		}
+	}
+}
+
+func TestTLSHandleAlert(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{newTestCert(t)}}
+	a, err := NewAcceptor(r, "127.0.0.1:0", func() EvHandler { return &tlsEcho{r: r} }, TLSConfig(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	raw, err := net.Dial("tcp", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(2 * time.Second))
+	conn := tls.Client(raw, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	if err = conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	// A record that can't be decrypted, the alert is written by tls.Conn.Read
+	raw.Write(append([]byte{0x17, 0x03, 0x03, 0x00, 0x20}, make([]byte, 0x20)...))
+	b := make([]byte, 16)
+	if n, err := raw.Read(b); n < 1 || b[0] != 0x15 {
+		t.Fatalf("alert not sent: %v", err)
+	}
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	r, err := NewReactor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{newTestCert(t)}}
+	_, err = NewAcceptor(r, "127.0.0.1:0", func() EvHandler { return &helloConn{} }, TLSConfig(cfg))
+	if err == nil {
+		t.Fatal("the EvHandler without TLSHandle accepted")
+	}
+	a, err := NewAcceptor(r, "127.0.0.1:0", func() EvHandler { return &tlsEcho{r: r} },
+		TLSConfig(cfg), TLSHandshakeTimeout(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go r.Run()
+	defer r.Stop()
+
+	conn, err := net.Dial("tcp", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte{0x16, 0x03, 0x01}) // An incomplete ClientHello
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	begin := time.Now()
+	if _, err = conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("read %v", err)
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Fatalf("closed after %s", d)
+	}
+}
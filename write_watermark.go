@@ -0,0 +1,111 @@
+package goev
+
+// writeWatermark is the backpressure config and state of IOHandle
+type writeWatermark struct {
+	high      int
+	low       int
+	limit     int // hard cap, ignore equal 0
+	pauseRead bool
+
+	aboveHigh bool
+	paused    bool
+	closing   bool
+}
+
+// SetWriteWatermark enables the backpressure on the data waiting to be sent (the sum of the unsent
+// data of Write/Writev/AsyncWrite). OnHighWatermark is called when it grows over `high',
+// then OnLowWatermark is called when it drops to `low' or below.
+// If pauseRead is true, EvIn is removed above the high watermark and restored at the low watermark,
+// so the peer that doesn't read can't make us receive more requests.
+//
+// It's disabled if high equal 0. Can only be used within the poller goroutine, e.g. in OnOpen
+func (h *IOHandle) SetWriteWatermark(high, low int, pauseRead bool) {
+	if high < 0 || low < 0 || (high > 0 && low >= high) {
+		panic("goev:SetWriteWatermark param is illegal")
+	}
+	if h.wm == nil {
+		h.wm = &writeWatermark{}
+	}
+	h.wm.high, h.wm.low, h.wm.pauseRead = high, low, pauseRead
+	if high == 0 && h.wm.aboveHigh {
+		h.onWriteBufShrink()
+	}
+}
+
+// SetWriteBufferLimit the connection will be closed (OnClose is called within the evpoll coroutine
+// later) if the data waiting to be sent exceeds n bytes, so a slow reader can't exhaust the memory.
+//
+// It's disabled if n equal 0. Can only be used within the poller goroutine, e.g. in OnOpen
+func (h *IOHandle) SetWriteBufferLimit(n int) {
+	if n < 0 {
+		panic("goev:SetWriteBufferLimit param is illegal")
+	}
+	if h.wm == nil {
+		h.wm = &writeWatermark{}
+	}
+	h.wm.limit = n
+}
+
+// WriteBufferedSize returns the total size of the data waiting to be sent
+// (include the unsent bytes of the file segments of SendFile)
+func (h *IOHandle) WriteBufferedSize() int {
+	return h.asyncWriteBufSize
+}
+
+// OnHighWatermark called when the data waiting to be sent grows over the high watermark
+// (within the evpoll coroutine), size is the current size
+func (h *IOHandle) OnHighWatermark(size int) {
+}
+
+// OnLowWatermark called when the data waiting to be sent drops to the low watermark
+// after OnHighWatermark (within the evpoll coroutine), size is the current size
+func (h *IOHandle) OnLowWatermark(size int) {
+}
+
+// onWriteBufGrow is called after asyncWriteBufSize increases
+func (h *IOHandle) onWriteBufGrow() {
+	wm := h.wm
+	if wm == nil {
+		return
+	}
+	size := h.asyncWriteBufSize
+	if wm.limit > 0 && size > wm.limit {
+		if !wm.closing && h.ep != nil {
+			wm.closing = true
+			// Don't close it synchronously, we may be in OnRead
+			h.ep.push(asyncWriteItem{fn: func() {
+				if wm == h.wm { // Not Init() for reusing
+					h.closeInPoll()
+				}
+			}})
+		}
+		return
+	}
+	if wm.high > 0 && !wm.aboveHigh && size > wm.high {
+		wm.aboveHigh = true
+		if wm.pauseRead && h.Fd() > 0 && h.ep.disableRead(h.Fd()) == nil {
+			wm.paused = true
+		}
+		if h.eh != nil {
+			h.eh.OnHighWatermark(size)
+		}
+	}
+}
+
+// onWriteBufShrink is called after asyncWriteBufSize decreases
+func (h *IOHandle) onWriteBufShrink() {
+	wm := h.wm
+	if wm == nil || !wm.aboveHigh || h.asyncWriteBufSize > wm.low {
+		return
+	}
+	wm.aboveHigh = false
+	if wm.paused {
+		wm.paused = false
+		if h.Fd() > 0 && !h.readStopped() {
+			h.ep.enableRead(h.Fd())
+		}
+	}
+	if h.eh != nil {
+		h.eh.OnLowWatermark(h.asyncWriteBufSize)
+	}
+}